@@ -0,0 +1,8 @@
+//go:build !bencoding_debug
+// +build !bencoding_debug
+
+package bencoding
+
+// debug enables additional, more expensive, consistency checks.
+// Build with the bencoding_debug tag to turn them on.
+const debug = false
@@ -3,11 +3,15 @@ package bencoding
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
 	"errors"
+	"hash"
 	"io"
-	"net/url"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 // Unmarshal parses the bencoded data and stores the result
@@ -16,41 +20,141 @@ import (
 // Unmarshal uses the inverse of the encodings that Marshal
 // uses, allocating maps, slices, pointers and strings as
 // necessary.
+//
+// If a value implements the Unmarshaler interface, Unmarshal
+// calls its UnmarshalBencode method with the raw bytes of
+// the corresponding bencode value.
 func Unmarshal(data []byte, v interface{}) error {
 	d := NewBytesDecoder(data)
 	return d.Decode(v)
 }
 
+// Unmarshaler is the interface implemented by types that
+// can unmarshal a bencode description of themselves.
+// The input is a single valid bencode value. UnmarshalBencode
+// must copy the data if it wishes to retain it after returning.
+//
+// The offsets of the decoder's errors returned by UnmarshalBencode,
+// such as SyntaxError and UnmarshalTypeError, are taken to count from
+// the start of the data; the decoder returns a copy moved, field path
+// included, to the position of the value in the whole input.
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
 type Decoder struct {
 	torrentDecoder *TorrentDecoder
+	tokenState     []tokenLevel
 }
 
 func NewDecoder(r io.Reader) *Decoder {
 	td := NewTorrentDecoder(r)
-	return &Decoder{td}
+	return &Decoder{torrentDecoder: td}
 }
 
 func NewBytesDecoder(b []byte) *Decoder {
 	td := NewBytesTorrentDecoder(b)
-	return &Decoder{td}
+	return &Decoder{torrentDecoder: td}
 }
 
 func NewStringDecoder(s string) *Decoder {
 	td := NewStringTorrentDecoder(s)
-	return &Decoder{td}
+	return &Decoder{torrentDecoder: td}
+}
+
+// DisallowUnknownFields causes the Decoder to return an error when
+// the destination is a struct and the input contains dictionary keys
+// which do not match any non-ignored field in the destination.
+func (d *Decoder) DisallowUnknownFields() {
+	d.torrentDecoder.DisallowUnknownFields()
+}
+
+// DisallowNonCanonical causes the Decoder to return an error when
+// the input is not in canonical bencode form.
+// See TorrentDecoder.DisallowNonCanonical for the rules.
+func (d *Decoder) DisallowNonCanonical() {
+	d.torrentDecoder.DisallowNonCanonical()
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's
+// buffer, that is input read from the underlying reader but not
+// consumed by Decode or Token. Together with the underlying reader
+// it continues the stream right after the last decoded value, for
+// example with io.MultiReader(d.Buffered(), r). The reader is
+// valid until the next call to Decode, Token or Skip.
+func (d *Decoder) Buffered() io.Reader {
+	return d.torrentDecoder.Buffered()
+}
+
+// InputOffset returns the input stream byte offset of the
+// current decoder position: the number of bytes consumed
+// by Decode, Token and Skip so far.
+func (d *Decoder) InputOffset() int64 {
+	return d.torrentDecoder.InputOffset()
+}
+
+// SetMaxStringLength limits the length of strings in the input.
+// See TorrentDecoder.SetMaxStringLength.
+func (d *Decoder) SetMaxStringLength(n int64) {
+	d.torrentDecoder.SetMaxStringLength(n)
+}
+
+// SetMaxDepth limits how deeply lists and dictionaries may be
+// nested. See TorrentDecoder.SetMaxDepth.
+func (d *Decoder) SetMaxDepth(n int) {
+	d.torrentDecoder.SetMaxDepth(n)
+}
+
+// SetMaxBytes limits the number of bytes consumed from the input.
+// See TorrentDecoder.SetMaxBytes.
+func (d *Decoder) SetMaxBytes(n int64) {
+	d.torrentDecoder.SetMaxBytes(n)
+}
+
+// SetMaxElements limits the number of list elements and dictionary
+// entries. See TorrentDecoder.SetMaxElements.
+func (d *Decoder) SetMaxElements(n int64) {
+	d.torrentDecoder.SetMaxElements(n)
 }
 
 func (d *Decoder) Decode(v interface{}) error {
+	if e := d.tokenValue(); e != nil {
+		return e
+	}
 	return d.torrentDecoder.unmarshal(v)
 }
 
 type TorrentDecoder struct {
-	b *hashingRreader
+	b                     *hashingRreader
+	path                  []string
+	disallowUnknownFields bool
+	canonical             bool
+	hashPath              []string
+	newHash               func() hash.Hash
+	hashFuncs             []func() hash.Hash // hashes computed by the current Decode
+	hashes                []hash.Hash
+	maxStringLength       int64
+	maxDepth              int
+	maxElements           int64
+	depth                 int
+	elements              int64
 }
 
+// defaultMaxDepth is the default nesting limit of lists and
+// dictionaries, deep enough for any real input while keeping
+// the recursive decoder well clear of stack exhaustion.
+const defaultMaxDepth = 10000
+
 func NewTorrentDecoder(r io.Reader) *TorrentDecoder {
-	hr := hashingRreader{bufio.NewReader(r), nil, false}
-	d := TorrentDecoder{&hr}
+	hr := hashingRreader{b: bufio.NewReader(r)}
+	d := TorrentDecoder{
+		b:        &hr,
+		hashPath: []string{"info"},
+		newHash:  sha1.New,
+		maxDepth: defaultMaxDepth,
+	}
 	return &d
 }
 
@@ -62,159 +166,792 @@ func NewStringTorrentDecoder(s string) *TorrentDecoder {
 	return NewBytesTorrentDecoder([]byte(s))
 }
 
-type InfoHash []byte
-
-func (i InfoHash) String() string {
-	return url.QueryEscape(string(i))
-}
-
 func UnmarshalTorrent(data []byte, v interface{}) (InfoHash, error) {
 	d := NewBytesTorrentDecoder(data)
 	return d.Decode(v)
 }
 
+// UnmarshalTorrentHashes is like UnmarshalTorrent, but returns
+// both the v1 and the v2 info hash. See TorrentDecoder.DecodeHashes.
+func UnmarshalTorrentHashes(data []byte, v interface{}) (InfoHashV1, InfoHashV2, error) {
+	d := NewBytesTorrentDecoder(data)
+	return d.DecodeHashes(v)
+}
+
+// Buffered returns a reader of the data remaining in the
+// TorrentDecoder's buffer. See Decoder.Buffered.
+func (d *TorrentDecoder) Buffered() io.Reader {
+	return bytes.NewReader(d.b.Buffered())
+}
+
+// InputOffset returns the input stream byte offset of the
+// current decoder position.
+func (d *TorrentDecoder) InputOffset() int64 {
+	return d.b.offset
+}
+
+// SetMaxStringLength limits the length of strings in the input.
+// Longer strings fail with a StringLengthError before any memory
+// is allocated for them. Zero, the default, means no limit.
+func (d *TorrentDecoder) SetMaxStringLength(n int64) {
+	d.maxStringLength = n
+}
+
+// SetMaxDepth limits how deeply lists and dictionaries may be
+// nested. Deeper input fails with a DepthError. The default
+// is 10000; zero means no limit.
+func (d *TorrentDecoder) SetMaxDepth(n int) {
+	d.maxDepth = n
+}
+
+// SetMaxBytes limits the total number of bytes the decoder consumes,
+// counted from the start of the input. Reading past the limit fails
+// with an InputSizeError. Zero, the default, means no limit.
+func (d *TorrentDecoder) SetMaxBytes(n int64) {
+	d.b.limit = n
+}
+
+// SetMaxElements limits the total number of list elements and
+// dictionary entries read over the lifetime of the decoder.
+// Exceeding it fails with an ElementCountError. Zero, the
+// default, means no limit.
+func (d *TorrentDecoder) SetMaxElements(n int64) {
+	d.maxElements = n
+}
+
+// DisallowUnknownFields causes the TorrentDecoder to return an error
+// when the destination is a struct and the input contains dictionary
+// keys which do not match any non-ignored field in the destination.
+func (d *TorrentDecoder) DisallowUnknownFields() {
+	d.disallowUnknownFields = true
+}
+
+// DisallowNonCanonical causes the TorrentDecoder to return an error
+// when the input is not in canonical form: dictionary keys must be
+// sorted as raw byte strings without duplicates, and integers and
+// string lengths must not have leading zeros, a plus sign or be "-0".
+// Canonical input always produces the same info hash for the
+// same decoded torrent.
+func (d *TorrentDecoder) DisallowNonCanonical() {
+	d.canonical = true
+}
+
+// SetHashedKeyPath sets the path of dictionary keys, starting
+// at the top level, leading to the value Decode hashes. The
+// default is the "info" key of the top level dictionary. An
+// empty path hashes the whole value.
+func (d *TorrentDecoder) SetHashedKeyPath(path ...string) {
+	d.hashPath = append([]string(nil), path...)
+}
+
+// SetHashFunc sets the function creating the hash Decode
+// computes. The default is sha1.New.
+func (d *TorrentDecoder) SetHashFunc(newHash func() hash.Hash) {
+	d.newHash = newHash
+}
+
+// HashedSpan returns the byte span of the input hashed by the
+// last call to Decode.
+func (d *TorrentDecoder) HashedSpan() Span {
+	return d.b.span
+}
+
+// Decode decodes the next value into v and returns the hash of
+// the value found at the hashed key path. It is an error if the
+// path is missing or occurs more than once.
 func (d *TorrentDecoder) Decode(v interface{}) (InfoHash, error) {
+	if hashes, e := d.decodeHashed(v, d.newHash); e != nil {
+		return "", e
+	} else {
+		return InfoHash(hashes[0].Sum(nil)), nil
+	}
+}
+
+// DecodeHashes is like Decode, but computes both the SHA-1 v1
+// and the SHA-256 v2 info hash in a single pass over the input,
+// as needed for BitTorrent v2 and hybrid torrents. The hash
+// function set with SetHashFunc is not used.
+func (d *TorrentDecoder) DecodeHashes(v interface{}) (InfoHashV1, InfoHashV2, error) {
+	var v1 InfoHashV1
+	var v2 InfoHashV2
+	hashes, e := d.decodeHashed(v, sha1.New, sha256.New)
+	if e != nil {
+		return v1, v2, e
+	}
+	copy(v1[:], hashes[0].Sum(nil))
+	copy(v2[:], hashes[1].Sum(nil))
+	return v1, v2, nil
+}
+
+// decodeHashed decodes the next value into v and returns a hash
+// created by each of hashFuncs of the value at the hashed key path.
+func (d *TorrentDecoder) decodeHashed(v interface{}, hashFuncs ...func() hash.Hash) ([]hash.Hash, error) {
+	d.hashFuncs = hashFuncs
+	defer func() { d.hashFuncs = nil }()
 	if e := d.unmarshal(v); e != nil {
 		return nil, e
-	} else if d.b.hash != nil {
-		return InfoHash(d.b.hash.Sum(nil)), nil
-	} else {
-		return nil, errors.New("missing info key")
 	}
+	if d.hashes == nil {
+		return nil, &MissingFieldError{Field: strings.Join(d.hashPath, "."), Offset: d.b.offset}
+	}
+	return d.hashes, nil
+}
+
+// startHashing feeds the value about to be read into
+// a fresh hash created by each of d.hashFuncs.
+func (d *TorrentDecoder) startHashing() {
+	d.hashes = make([]hash.Hash, len(d.hashFuncs))
+	writers := make([]io.Writer, len(d.hashFuncs))
+	for i, newHash := range d.hashFuncs {
+		d.hashes[i] = newHash()
+		writers[i] = d.hashes[i]
+	}
+	d.b.StartHashing(io.MultiWriter(writers...))
+}
+
+// leadsToHashedPath reports whether the current path
+// is a proper prefix of the hashed key path.
+func (d *TorrentDecoder) leadsToHashedPath() bool {
+	if len(d.hashFuncs) == 0 || len(d.path) >= len(d.hashPath) {
+		return false
+	}
+	for i := range d.path {
+		if d.path[i] != d.hashPath[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// atHashedPath reports whether the value about to be
+// decoded is the one at the hashed key path.
+func (d *TorrentDecoder) atHashedPath() bool {
+	if len(d.hashFuncs) == 0 || len(d.path) != len(d.hashPath) || len(d.path) == 0 {
+		return false
+	}
+	for i := range d.path {
+		if d.path[i] != d.hashPath[i] {
+			return false
+		}
+	}
+	return true
 }
 
+// peek returns the next byte of the value being decoded.
+// Running out of input is a syntax error.
 func (d *TorrentDecoder) peek() (byte, error) {
 	if b, e := d.b.Peek(1); e != nil {
-		return ' ', e
+		return ' ', d.inputError(e)
 	} else {
 		return b[0], nil
 	}
 }
 
+func (d *TorrentDecoder) syntaxError(msg string) error {
+	return d.syntaxErrorAt(msg, d.b.offset)
+}
+
+func (d *TorrentDecoder) syntaxErrorAt(msg string, offset int64) error {
+	return &SyntaxError{Offset: offset, Msg: msg, Field: d.fieldPath()}
+}
+
+// inputError converts a read error into a SyntaxError when
+// the input ended in the middle of a value.
+func (d *TorrentDecoder) inputError(e error) error {
+	switch e {
+	case io.EOF, io.ErrUnexpectedEOF:
+		return d.syntaxError("unexpected end of input")
+	case bufio.ErrBufferFull:
+		return d.syntaxError("integer or string length too long")
+	case errInputLimit:
+		return &InputSizeError{Limit: d.b.limit}
+	}
+	return e
+}
+
+// enterContainer records the start of a list or dictionary.
+func (d *TorrentDecoder) enterContainer() error {
+	d.depth++
+	if d.maxDepth > 0 && d.depth > d.maxDepth {
+		return &DepthError{Limit: d.maxDepth, Offset: d.b.offset}
+	}
+	return nil
+}
+
+// leaveContainer records the end of a list or dictionary.
+func (d *TorrentDecoder) leaveContainer() {
+	d.depth--
+}
+
+// countElement records a list element or dictionary entry.
+func (d *TorrentDecoder) countElement() error {
+	d.elements++
+	if d.maxElements > 0 && d.elements > d.maxElements {
+		return &ElementCountError{Limit: d.maxElements, Offset: d.b.offset}
+	}
+	return nil
+}
+
+// typeError reports that the next value in the input
+// can not be stored in a Go value of type t. Input that
+// starts no value at all is a syntax error instead.
+func (d *TorrentDecoder) typeError(t reflect.Type) error {
+	kind := d.peekKind()
+	if kind == "" {
+		b, e := d.peek()
+		if e != nil {
+			return e
+		}
+		return d.syntaxError("invalid character '" + string(b) + "' looking for beginning of value")
+	}
+	return d.typeErrorAt(kind, t, d.b.offset)
+}
+
+func (d *TorrentDecoder) typeErrorAt(value string, t reflect.Type, offset int64) error {
+	return &UnmarshalTypeError{Value: value, Type: t, Offset: offset, Field: d.fieldPath()}
+}
+
+// peekKind describes the kind of the next value in the input,
+// or returns "" if the next byte cannot start a value.
+func (d *TorrentDecoder) peekKind() string {
+	b, _ := d.peek()
+	switch b {
+	case 'i':
+		return "integer"
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return "string"
+	case 'l':
+		return "list"
+	case 'd':
+		return "dictionary"
+	}
+	return ""
+}
+
 func (d *TorrentDecoder) unmarshal(v interface{}) error {
+	d.path = d.path[:0]
+	defer d.restoreDepth(d.depth)
+	d.b.ResetHash()
+	d.hashes = nil
+	if len(d.hashFuncs) > 0 && len(d.hashPath) == 0 {
+		d.startHashing()
+		defer d.b.StopHashing()
+	}
 	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	if _, e := d.b.Peek(1); e == io.EOF {
+		return e
+	}
 	return d.unmarshalToVal(val)
 }
 
 func (d *TorrentDecoder) unmarshalToVal(val reflect.Value) error {
-	if val.Kind() != reflect.Ptr {
-		return errors.New("Can only unmarshal pointers")
-	}
 	val = val.Elem()
-	switch val.Kind() {
+	return typeDecoder(val.Type())(d, val)
+}
+
+// decoderFunc decodes the next value in the input into
+// an addressable value of a particular type.
+type decoderFunc func(d *TorrentDecoder, val reflect.Value) error
+
+var decoderCache sync.Map // map[reflect.Type]decoderFunc
+
+// typeDecoder returns the cached decoder for values of type t.
+func typeDecoder(t reflect.Type) decoderFunc {
+	if fi, ok := decoderCache.Load(t); ok {
+		return fi.(decoderFunc)
+	}
+	fi, _ := decoderCache.LoadOrStore(t, newTypeDecoder(t))
+	return fi.(decoderFunc)
+}
+
+func newTypeDecoder(t reflect.Type) decoderFunc {
+	if t == valueType {
+		return (*TorrentDecoder).unmarshalValue
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return (*TorrentDecoder).unmarshalUnmarshaler
+	}
+	switch t.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return d.unmarshalInt(val)
+		return (*TorrentDecoder).unmarshalInt
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return d.unmarshalUint(val)
+		return (*TorrentDecoder).unmarshalUint
 	case reflect.String:
-		return d.unmarshalString(val)
+		return (*TorrentDecoder).unmarshalString
 	case reflect.Slice:
-		return d.unmarshalSlice(val)
+		return (*TorrentDecoder).unmarshalSlice
 	case reflect.Map:
-		return d.unmarshalMap(val)
+		return (*TorrentDecoder).unmarshalMap
 	case reflect.Struct:
-		return d.unmarshalStruct(val)
+		return (*TorrentDecoder).unmarshalStruct
+	case reflect.Ptr:
+		return (*TorrentDecoder).unmarshalPtr
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return (*TorrentDecoder).unmarshalInterface
+		}
 	}
-	return errors.New("Unsupported type encountered")
+	return func(d *TorrentDecoder, val reflect.Value) error {
+		return d.typeError(val.Type())
+	}
+}
+
+func (d *TorrentDecoder) unmarshalPtr(val reflect.Value) error {
+	prepare(val)
+	return d.unmarshalToVal(val)
+}
+
+func (d *TorrentDecoder) unmarshalInterface(val reflect.Value) error {
+	if newValue, e := d.unmarshalUnknownItem(); e != nil {
+		return e
+	} else {
+		val.Set(newValue)
+	}
+	return nil
+}
+
+// unmarshalUnmarshaler passes the raw bytes of the next value
+// to the UnmarshalBencode method of val's address.
+func (d *TorrentDecoder) unmarshalUnmarshaler(val reflect.Value) error {
+	start := d.b.offset
+	raw, e := d.readValue(nil)
+	if e != nil {
+		return e
+	}
+	if e := val.Addr().Interface().(Unmarshaler).UnmarshalBencode(raw); e != nil {
+		return d.relocateError(e, start)
+	}
+	return nil
+}
+
+// relocateError moves an error returned by an Unmarshaler for
+// the value starting at start to that value's place in the input.
+// The error is copied rather than changed, and an error wrapping
+// one of the decoder's errors is replaced by the relocated copy.
+func (d *TorrentDecoder) relocateError(e error, start int64) error {
+	var syntaxErr *SyntaxError
+	var typeErr *UnmarshalTypeError
+	var unknownErr *UnknownFieldError
+	var missingErr *MissingFieldError
+	var lengthErr *StringLengthError
+	var depthErr *DepthError
+	var countErr *ElementCountError
+	switch {
+	case errors.As(e, &syntaxErr):
+		c := *syntaxErr
+		c.Offset += start
+		c.Field = joinFieldPath(d.fieldPath(), c.Field)
+		return &c
+	case errors.As(e, &typeErr):
+		c := *typeErr
+		c.Offset += start
+		c.Field = joinFieldPath(d.fieldPath(), c.Field)
+		return &c
+	case errors.As(e, &unknownErr):
+		c := *unknownErr
+		c.Offset += start
+		c.Field = joinFieldPath(d.fieldPath(), c.Field)
+		return &c
+	case errors.As(e, &missingErr):
+		c := *missingErr
+		c.Offset += start
+		c.Field = joinFieldPath(d.fieldPath(), c.Field)
+		return &c
+	case errors.As(e, &lengthErr):
+		c := *lengthErr
+		c.Offset += start
+		return &c
+	case errors.As(e, &depthErr):
+		c := *depthErr
+		c.Offset += start
+		return &c
+	case errors.As(e, &countErr):
+		c := *countErr
+		c.Offset += start
+		return &c
+	}
+	return e
+}
+
+// readValue reads one complete bencode value and appends
+// its raw bytes to out.
+func (d *TorrentDecoder) readValue(out []byte) ([]byte, error) {
+	return d.scanValue(out, false)
+}
+
+// restoreDepth resets the nesting depth after a
+// value which may have failed half way through.
+func (d *TorrentDecoder) restoreDepth(depth int) {
+	d.depth = depth
+}
+
+// skipValue reads and validates one complete bencode value
+// without keeping its content.
+func (d *TorrentDecoder) skipValue() error {
+	_, e := d.scanValue(nil, true)
+	return e
+}
+
+// skipUnusedValue skips a value the caller has no use for,
+// still descending into dictionaries that lead to the hashed
+// key path so that the value there gets hashed.
+func (d *TorrentDecoder) skipUnusedValue() error {
+	if b, e := d.peek(); e != nil {
+		return e
+	} else if b != 'd' || !d.leadsToHashedPath() {
+		return d.skipValue()
+	}
+	return d.unmarshalDict(func(string, Span) error {
+		return d.skipUnusedValue()
+	})
+}
+
+// scanValue reads one complete bencode value. Unless discard
+// is set, its raw bytes are appended to out.
+func (d *TorrentDecoder) scanValue(out []byte, discard bool) ([]byte, error) {
+	b, e := d.peek()
+	if e != nil {
+		return out, e
+	}
+	switch b {
+	case 'i':
+		data, e := d.readIntDigits()
+		if e != nil {
+			return out, e
+		}
+		if _, e := strconv.ParseInt(string(data), 10, 64); e != nil {
+			if _, e := strconv.ParseUint(string(data), 10, 64); e != nil {
+				return out, d.syntaxError("invalid integer 'i" + string(data) + "e'")
+			}
+		}
+		if discard {
+			return out, nil
+		}
+		out = append(out, 'i')
+		out = append(out, data...)
+		return append(out, 'e'), nil
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		if discard {
+			return out, d.skipString()
+		}
+		lStr, content, e := d.readString()
+		if e != nil {
+			return out, e
+		}
+		out = append(out, lStr...)
+		return append(out, content...), nil
+	case 'l', 'd':
+		d.b.ReadByte()
+		if e := d.enterContainer(); e != nil {
+			return out, e
+		}
+		if !discard {
+			out = append(out, b)
+		}
+		// Containers on the way to the hashed key path are followed
+		// key by key, so that the value there is hashed even when
+		// it is read as raw bytes.
+		track := d.leadsToHashedPath()
+		var prevKey []byte
+		for i := 0; ; i++ {
+			next, e := d.peek()
+			if e != nil {
+				return out, e
+			} else if next == 'e' {
+				d.b.ReadByte()
+				d.leaveContainer()
+				if discard {
+					return out, nil
+				}
+				return append(out, next), nil
+			}
+			if e := d.countElement(); e != nil {
+				return out, e
+			}
+			hashed := false
+			if b == 'd' {
+				if next < '0' || next > '9' {
+					return out, d.syntaxError("dictionary key must be a string")
+				}
+				offset := d.b.offset
+				lStr, key, e := d.readString()
+				if e != nil {
+					return out, e
+				}
+				if e := d.checkKeyOrder(prevKey, key, i == 0, offset); e != nil {
+					return out, e
+				}
+				prevKey = key
+				if !discard {
+					out = append(out, lStr...)
+					out = append(out, key...)
+				}
+				if track {
+					d.path = append(d.path, string(key))
+					if hashed = d.atHashedPath(); hashed {
+						if d.hashes != nil {
+							return out, d.syntaxErrorAt("duplicate key '"+string(key)+"'", offset)
+						}
+						d.startHashing()
+					}
+				}
+			} else if track {
+				d.path = append(d.path, "["+strconv.Itoa(i)+"]")
+			}
+			if out, e = d.scanValue(out, discard); e != nil {
+				return out, e
+			}
+			if hashed {
+				d.b.StopHashing()
+			}
+			if track {
+				d.path = d.path[:len(d.path)-1]
+			}
+		}
+	default:
+		return out, d.syntaxError("invalid character '" + string(b) + "' looking for beginning of value")
+	}
+}
+
+// isValidBencode reports whether b holds exactly one
+// well formed bencode value.
+func isValidBencode(b []byte) bool {
+	d := NewBytesTorrentDecoder(b)
+	if _, e := d.readValue(nil); e != nil {
+		return false
+	}
+	_, e := d.b.Peek(1)
+	return e == io.EOF
 }
 
 func (d *TorrentDecoder) unmarshalSlice(v reflect.Value) error {
 	if v.Type().Elem().Kind() == reflect.Uint8 {
-		var s string
-		sv := reflect.ValueOf(&s).Elem()
-		if e := d.unmarshalString(sv); e != nil {
+		if b, e := d.peek(); e != nil {
+			return e
+		} else if b < '0' || b > '9' {
+			return d.typeError(v.Type())
+		}
+		_, content, e := d.readString()
+		if e != nil {
 			return e
 		}
-		v.SetBytes([]byte(s))
+		v.SetBytes(content)
 		return nil
 	}
 
 	if b, e := d.peek(); e != nil {
 		return e
 	} else if b != 'l' {
-		return errors.New("malformed list beggining (missing 'l')")
+		return d.typeError(v.Type())
 	}
 	d.b.ReadByte()
-	for {
+	if e := d.enterContainer(); e != nil {
+		return e
+	}
+	if !v.IsNil() {
+		v.SetLen(0)
+	}
+	for i := 0; ; i++ {
 		if b, e := d.peek(); e != nil {
 			return e
 		} else if b == 'e' {
 			break
 		}
-		if newValue, e := d.unmarshalUnknownItem(); e != nil {
-			return nil
-		} else {
-			v.Set(reflect.Append(v, newValue))
+		if e := d.countElement(); e != nil {
+			return e
+		}
+		elem := reflect.New(v.Type().Elem())
+		d.path = append(d.path, "["+strconv.Itoa(i)+"]")
+		if e := d.unmarshalToVal(elem); e != nil {
+			return e
 		}
+		d.path = d.path[:len(d.path)-1]
+		v.Set(reflect.Append(v, elem.Elem()))
 	}
 
-	if b, e := d.peek(); e != nil {
-		return e
-	} else if b != 'e' {
-		return errors.New("malformed list end (missing 'e')")
-	}
 	d.b.ReadByte()
+	d.leaveContainer()
 	return nil
 }
 
 func (d *TorrentDecoder) unmarshalMap(v reflect.Value) error {
+	if b, e := d.peek(); e != nil {
+		return e
+	} else if b != 'd' || v.Type().Key().Kind() != reflect.String {
+		return d.typeError(v.Type())
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	return d.unmarshalDict(func(key string, _ Span) error {
+		elem := reflect.New(v.Type().Elem())
+		if e := d.unmarshalToVal(elem); e != nil {
+			return e
+		}
+		v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem.Elem())
+		return nil
+	})
+}
+
+// unmarshalDict reads a dictionary, calling value with each key
+// and its span to consume the value that follows it.
+func (d *TorrentDecoder) unmarshalDict(value func(key string, keySpan Span) error) error {
 	if b, e := d.peek(); e != nil {
 		return e
 	} else if b != 'd' {
-		return errors.New("Malformed dict")
+		return d.syntaxError("malformed dictionary beginning (missing 'd')")
 	}
 	d.b.ReadByte()
-	for {
+	if e := d.enterContainer(); e != nil {
+		return e
+	}
+	var prevKey string
+	for i := 0; ; i++ {
 		if b, e := d.peek(); e != nil {
 			return e
 		} else if b == 'e' {
 			break
 		}
-		if e := d.unmarshalKeyValuePair(v); e != nil {
+		if e := d.countElement(); e != nil {
 			return e
 		}
-	}
-	if b, e := d.peek(); e != nil {
-		return e
-	} else if b != 'e' {
-		return errors.New("Malformed dict")
+		if key, e := d.unmarshalKeyValuePair(prevKey, i == 0, value); e != nil {
+			return e
+		} else {
+			prevKey = key
+		}
 	}
 	d.b.ReadByte()
+	d.leaveContainer()
 	return nil
 }
 
-func (d *TorrentDecoder) unmarshalKeyValuePair(v reflect.Value) error {
+func (d *TorrentDecoder) unmarshalKeyValuePair(prevKey string, first bool, value func(key string, keySpan Span) error) (string, error) {
 	var key string
+	if b, e := d.peek(); e != nil {
+		return key, e
+	} else if b < '0' || b > '9' {
+		return key, d.syntaxError("dictionary key must be a string")
+	}
+	offset := d.b.offset
 	keyv := reflect.ValueOf(&key).Elem()
 	if e := d.unmarshalString(keyv); e != nil {
-		return e
+		return key, e
+	}
+	if e := d.checkKeyOrder([]byte(prevKey), []byte(key), first, offset); e != nil {
+		return key, e
 	}
 
-	var infoEncountered bool
-	if key == "info" {
-		infoEncountered = true
-		d.b.StartHasing()
+	keySpan := Span{Start: offset, End: d.b.offset}
+	d.path = append(d.path, key)
+	hashed := d.atHashedPath()
+	if hashed {
+		if d.hashes != nil {
+			return key, d.syntaxErrorAt("duplicate key '"+key+"'", offset)
+		}
+		d.startHashing()
+	}
+	if e := value(key, keySpan); e != nil {
+		return key, e
 	}
+	if hashed {
+		d.b.StopHashing()
+	}
+	d.path = d.path[:len(d.path)-1]
+	return key, nil
+}
 
-	if newValue, e := d.unmarshalUnknownItem(); e != nil {
+// unmarshalStruct decodes each dictionary entry straight
+// into the struct field with the matching key.
+func (d *TorrentDecoder) unmarshalStruct(v reflect.Value) error {
+	if b, e := d.peek(); e != nil {
 		return e
-	} else {
-		v.SetMapIndex(keyv, newValue)
+	} else if b != 'd' {
+		return d.typeError(v.Type())
 	}
-
-	if infoEncountered {
-		d.b.StopHashing()
+	fields := cachedTypeFields(v.Type())
+	present := make([]bool, len(fields.list))
+	e := d.unmarshalDict(func(key string, keySpan Span) error {
+		i, known := fields.byName[key]
+		if !known && d.disallowUnknownFields {
+			return &UnknownFieldError{Field: d.fieldPath(), Offset: keySpan.Start}
+		}
+		if !known || !fields.list[i].settable {
+			return d.skipUnusedValue()
+		}
+		present[i] = true
+		f := v.Field(fields.list[i].index)
+		if fields.list[i].asString {
+			return d.unmarshalStringNumber(f)
+		}
+		return d.unmarshalToVal(f.Addr())
+	})
+	if e != nil {
+		return e
+	}
+	for i, f := range fields.list {
+		if f.required && !present[i] {
+			return &MissingFieldError{Field: joinFieldPath(d.fieldPath(), f.name), Offset: d.b.offset}
+		}
 	}
 	return nil
 }
 
-func (d *TorrentDecoder) unmarshalStruct(v reflect.Value) error {
-	di := make(map[string]interface{})
-	dv := reflect.ValueOf(&di).Elem()
-	if e := d.unmarshalMap(dv); e != nil {
+// unmarshalStringNumber decodes a string holding a decimal
+// number into an integer (or pointer to integer) field.
+func (d *TorrentDecoder) unmarshalStringNumber(v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		prepare(v)
+		v = v.Elem()
+	}
+	offset := d.b.offset
+	var s string
+	if e := d.unmarshalString(reflect.ValueOf(&s).Elem()); e != nil {
+		if _, ok := e.(*UnmarshalTypeError); ok {
+			return d.typeError(v.Type())
+		}
 		return e
 	}
-	return bind(di, v)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, e := strconv.ParseInt(s, 10, v.Type().Bits()); e == nil {
+			v.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u, e := strconv.ParseUint(s, 10, v.Type().Bits()); e == nil {
+			v.SetUint(u)
+			return nil
+		}
+	}
+	return d.typeErrorAt("string "+strconv.Quote(s), v.Type(), offset)
+}
+
+func prepare(v reflect.Value) {
+	if v.IsNil() {
+		v.Set(reflect.New(v.Type().Elem()))
+	}
+}
+
+// joinFieldPath appends the relative path rel to path.
+func joinFieldPath(path, rel string) string {
+	if path == "" || rel == "" || strings.HasPrefix(rel, "[") {
+		return path + rel
+	}
+	return path + "." + rel
+}
+
+// fieldPath describes the location of the value being decoded,
+// for example "info.files[3].length".
+func (d *TorrentDecoder) fieldPath() string {
+	var path string
+	for _, p := range d.path {
+		path = joinFieldPath(path, p)
+	}
+	return path
 }
 
 func (d *TorrentDecoder) unmarshalUnknownItem() (reflect.Value, error) {
@@ -256,7 +993,7 @@ func (d *TorrentDecoder) unmarshalUnknownItem() (reflect.Value, error) {
 			return dv, nil
 		}
 	default:
-		return reflect.Value{}, errors.New("Unknonw item")
+		return reflect.Value{}, d.syntaxError("invalid character '" + string(b) + "' looking for beginning of value")
 	}
 }
 
@@ -264,17 +1001,17 @@ func (d *TorrentDecoder) unmarshalInt(v reflect.Value) error {
 	if b, e := d.peek(); e != nil {
 		return e
 	} else if b != 'i' {
-		return errors.New("Malformed integer input")
+		return d.typeError(v.Type())
 	}
-	d.b.ReadByte()
-	if data, e := d.b.ReadBytes('e'); e != nil {
+	offset := d.b.offset
+	if data, e := d.readIntDigits(); e != nil {
 		return e
+	} else if val, e := strconv.ParseInt(string(data), 10, 64); e != nil {
+		return d.numberError(data, e, v.Type(), offset)
+	} else if v.OverflowInt(val) {
+		return d.typeErrorAt("integer "+string(data), v.Type(), offset)
 	} else {
-		if val, e := strconv.ParseInt(string(data[:len(data)-1]), 10, 64); e != nil {
-			return e
-		} else {
-			v.SetInt(val)
-		}
+		v.SetInt(val)
 	}
 	return nil
 }
@@ -283,49 +1020,183 @@ func (d *TorrentDecoder) unmarshalUint(v reflect.Value) error {
 	if b, e := d.peek(); e != nil {
 		return e
 	} else if b != 'i' {
-		return errors.New("Malformed integer input")
+		return d.typeError(v.Type())
 	}
-	d.b.ReadByte()
-	if data, e := d.b.ReadBytes('e'); e != nil {
+	offset := d.b.offset
+	if data, e := d.readIntDigits(); e != nil {
 		return e
+	} else if val, e := strconv.ParseUint(string(data), 10, 64); e != nil {
+		return d.numberError(data, e, v.Type(), offset)
+	} else if v.OverflowUint(val) {
+		return d.typeErrorAt("integer "+string(data), v.Type(), offset)
 	} else {
-		if val, e := strconv.ParseUint(string(data[:len(data)-1]), 10, 64); e != nil {
-			return e
-		} else {
-			v.SetUint(val)
-		}
+		v.SetUint(val)
 	}
 	return nil
 }
 
-// TODO: maybe this should be implemented in a more
-// efficent manner...
-func readExactly(b *hashingRreader, out []byte) error {
-	pos := 0
-	for pos < len(out) {
-		if b, e := b.ReadByte(); e != nil {
-			return e
-		} else {
-			out[pos] = b
-			pos++
+// numberError converts a strconv error for data into an
+// UnmarshalTypeError when data is a valid integer out of range
+// of t, such as a negative one for an unsigned type, and into
+// a SyntaxError otherwise.
+func (d *TorrentDecoder) numberError(data []byte, e error, t reflect.Type, offset int64) error {
+	if isRangeError(e) {
+		return d.typeErrorAt("integer "+string(data), t, offset)
+	}
+	if _, e := strconv.ParseInt(string(data), 10, 64); e == nil || isRangeError(e) {
+		return d.typeErrorAt("integer "+string(data), t, offset)
+	}
+	return d.syntaxErrorAt("invalid integer 'i"+string(data)+"e'", offset)
+}
+
+func isRangeError(e error) bool {
+	ne, ok := e.(*strconv.NumError)
+	return ok && ne.Err == strconv.ErrRange
+}
+
+// readIntDigits reads an integer and returns the text between
+// the 'i' and the 'e'.
+func (d *TorrentDecoder) readIntDigits() ([]byte, error) {
+	if b, e := d.peek(); e != nil {
+		return nil, e
+	} else if b != 'i' {
+		return nil, d.syntaxError("malformed integer beginning (missing 'i')")
+	}
+	offset := d.b.offset
+	d.b.ReadByte()
+	data, e := d.b.ReadBytes('e')
+	if e != nil {
+		return nil, d.inputError(e)
+	}
+	data = data[:len(data)-1]
+	if d.canonical && !isCanonicalNumber(data, true) {
+		return nil, d.syntaxErrorAt("non-canonical integer 'i"+string(data)+"e'", offset)
+	}
+	return data, nil
+}
+
+// isCanonicalNumber reports whether b is a decimal number without
+// a plus sign or leading zeros. Negative numbers other than "-0"
+// are allowed only when signed is true.
+func isCanonicalNumber(b []byte, signed bool) bool {
+	if signed && len(b) > 0 && b[0] == '-' {
+		b = b[1:]
+		if len(b) > 0 && b[0] == '0' {
+			return false
 		}
 	}
-	return nil
+	if len(b) == 0 || (b[0] == '0' && len(b) > 1) {
+		return false
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func readExactly(b *hashingRreader, out []byte) error {
+	_, e := io.ReadFull(b, out)
+	return e
 }
 
 func (d *TorrentDecoder) unmarshalString(v reflect.Value) error {
-	lStr, e := d.b.ReadBytes(':')
-	if e != nil {
+	if b, e := d.peek(); e != nil {
 		return e
+	} else if b < '0' || b > '9' {
+		return d.typeError(v.Type())
 	}
-	length, e := strconv.ParseInt(string(lStr[:len(lStr)-1]), 10, 64)
+	_, content, e := d.readString()
 	if e != nil {
 		return e
 	}
-	content := make([]byte, length)
-	if e = readExactly(d.b, content); e != nil {
+	v.SetString(string(content))
+	return nil
+}
+
+// readString reads a string and returns its length prefix
+// (colon included) and its content.
+func (d *TorrentDecoder) readString() ([]byte, []byte, error) {
+	lStr, length, e := d.readStringLength()
+	if e != nil {
+		return nil, nil, e
+	}
+	content, e := d.readContent(length)
+	if e != nil {
+		return nil, nil, e
+	}
+	return lStr, content, nil
+}
+
+// contentChunk is the largest string allocated up front; longer
+// strings grow as their bytes arrive, so a bogus length prefix
+// cannot make the decoder allocate memory the input never fills.
+const contentChunk = 64 << 10
+
+func (d *TorrentDecoder) readContent(length int64) ([]byte, error) {
+	if length <= contentChunk {
+		content := make([]byte, length)
+		if e := readExactly(d.b, content); e != nil {
+			return nil, d.inputError(e)
+		}
+		return content, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(contentChunk)
+	if _, e := io.CopyN(&buf, d.b, length); e != nil {
+		return nil, d.inputError(e)
+	}
+	return buf.Bytes(), nil
+}
+
+// skipString reads a string without keeping its content.
+func (d *TorrentDecoder) skipString() error {
+	_, length, e := d.readStringLength()
+	if e != nil {
 		return e
 	}
-	v.SetString(string(content))
+	if e = d.b.Discard(length); e != nil {
+		return d.inputError(e)
+	}
+	return nil
+}
+
+// readStringLength reads the length prefix of a string
+// and returns it (colon included) with its value.
+func (d *TorrentDecoder) readStringLength() ([]byte, int64, error) {
+	offset := d.b.offset
+	lStr, e := d.b.ReadBytes(':')
+	if e != nil {
+		return nil, 0, d.inputError(e)
+	}
+	if d.canonical && !isCanonicalNumber(lStr[:len(lStr)-1], false) {
+		return nil, 0, d.syntaxErrorAt("non-canonical string length '"+string(lStr)+"'", offset)
+	}
+	length, e := strconv.ParseInt(string(lStr[:len(lStr)-1]), 10, 64)
+	if e != nil || length < 0 {
+		return nil, 0, d.syntaxErrorAt("invalid string length '"+string(lStr)+"'", offset)
+	}
+	if d.maxStringLength > 0 && length > d.maxStringLength {
+		return nil, 0, &StringLengthError{Length: length, Limit: d.maxStringLength, Offset: offset}
+	}
+	if !d.b.allows(length) {
+		return nil, 0, &InputSizeError{Limit: d.b.limit}
+	}
+	return lStr, length, nil
+}
+
+// checkKeyOrder verifies, in canonical mode, that key sorts
+// strictly after prev, the previous key of the same dictionary.
+func (d *TorrentDecoder) checkKeyOrder(prev []byte, key []byte, first bool, offset int64) error {
+	if !d.canonical || first {
+		return nil
+	}
+	switch bytes.Compare(prev, key) {
+	case 0:
+		return d.syntaxErrorAt("duplicate dictionary key '"+string(key)+"'", offset)
+	case 1:
+		return d.syntaxErrorAt("dictionary key '"+string(key)+"' is not sorted after '"+string(prev)+"'", offset)
+	}
 	return nil
 }
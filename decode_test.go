@@ -1,6 +1,13 @@
 package bencoding
 
 import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -320,8 +327,8 @@ func TestUnmarshalTorrentFromStringWitoutTorrentData(t *testing.T) {
 	var i int
 	b := []byte("i5e")
 	h, e := UnmarshalTorrent(b, &i)
-	if e == nil || h != nil {
-		t.Fatalf("Expected to receive nil hash and error, got '%v' and '%v'", h, e)
+	if e == nil || h != "" {
+		t.Fatalf("Expected to receive empty hash and error, got '%v' and '%v'", h, e)
 	}
 }
 
@@ -336,3 +343,745 @@ func TestUnmarshalStructWithStringSlice(t *testing.T) {
 		t.Fatal(e)
 	}
 }
+
+type version struct {
+	major, minor int64
+}
+
+func (v *version) UnmarshalBencode(b []byte) error {
+	var l []interface{}
+	if e := Unmarshal(b, &l); e != nil {
+		return e
+	}
+	if len(l) != 2 {
+		return errors.New("version needs two elements")
+	}
+	v.major, v.minor = l[0].(int64), l[1].(int64)
+	return nil
+}
+
+func TestUnmarshalerIsUsed(t *testing.T) {
+	var v version
+	if e := Unmarshal([]byte("li1ei2ee"), &v); e != nil {
+		t.Fatal(e)
+	} else if v.major != 1 || v.minor != 2 {
+		t.Fatalf("Expected {1 2} got '%v'", v)
+	}
+
+	type T struct {
+		V   version  `bencoding:"v"`
+		P   *version `bencoding:"p"`
+		Foo string
+	}
+	var ts T
+	if e := Unmarshal([]byte("d3:Foo3:bar1:pli3ei4ee1:vli5ei6eee"), &ts); e != nil {
+		t.Fatal(e)
+	} else if ts.V.major != 5 || ts.V.minor != 6 || ts.P == nil || ts.P.major != 3 || ts.P.minor != 4 || ts.Foo != "bar" {
+		t.Fatalf("Did not expect to get '%v'", ts)
+	}
+}
+
+func TestUnmarshalerErrorIsReturned(t *testing.T) {
+	var v version
+	if e := Unmarshal([]byte("li1ee"), &v); e == nil {
+		t.Fatalf("Expected error for one element version")
+	}
+}
+
+type intPair [2]int64
+
+func (p *intPair) UnmarshalBencode(b []byte) error {
+	var l []int64
+	if e := Unmarshal(b, &l); e != nil {
+		return e
+	}
+	copy(p[:], l)
+	return nil
+}
+
+func TestUnmarshalerErrorsAreRelocated(t *testing.T) {
+	type T struct {
+		V intPair `bencoding:"v"`
+	}
+	var v T
+	e := Unmarshal([]byte("d1:vli1e3:abcee"), &v)
+	if te, ok := e.(*UnmarshalTypeError); !ok || te.Field != "v[1]" || te.Offset != 8 {
+		t.Fatalf("Expected type error at 'v[1]' offset 8, got %v", e)
+	}
+
+	shared := &SyntaxError{Msg: "bad", Offset: 1}
+	var w struct {
+		A failing `bencoding:"a"`
+		B failing `bencoding:"b"`
+	}
+	for i, in := range []string{"d1:ai1ee", "d1:bi1ee"} {
+		failingErr = shared
+		if i == 1 {
+			failingErr = fmt.Errorf("wrapped: %w", shared)
+		}
+		var se *SyntaxError
+		if e := Unmarshal([]byte(in), &w); !errors.As(e, &se) || se.Offset != 5 || se.Field != in[3:4] {
+			t.Fatalf("Expected relocated error for '%s', got %v", in, e)
+		}
+	}
+	if shared.Offset != 1 || shared.Field != "" {
+		t.Fatalf("Expected the returned error not to be changed, got %+v", shared)
+	}
+}
+
+// failing is an Unmarshaler that always fails with failingErr.
+type failing struct{}
+
+var failingErr error
+
+func (failing) UnmarshalBencode([]byte) error {
+	return failingErr
+}
+
+func TestUnmarshalRawMessageKeepsExactBytes(t *testing.T) {
+	type Inner struct {
+		Raw RawMessage `bencoding:"raw"`
+	}
+	type T struct {
+		Announce string     `bencoding:"announce"`
+		Info     RawMessage `bencoding:"info"`
+		Inner    Inner      `bencoding:"inner"`
+	}
+	// keys of info are deliberately not sorted
+	s := "d8:announce3:foo4:infod1:bi2e1:ai1ee5:innerd3:rawli1e2:xyeee"
+	var v T
+	h, e := UnmarshalTorrent([]byte(s), &v)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(v.Info) != "d1:bi2e1:ai1ee" {
+		t.Fatalf("Expected raw info 'd1:bi2e1:ai1ee' got '%s'", string(v.Info))
+	}
+	if sum := sha1.Sum(v.Info); h != InfoHash(sum[:]) {
+		t.Fatalf("Expected info hash to match raw info bytes")
+	}
+	if string(v.Inner.Raw) != "li1e2:xye" {
+		t.Fatalf("Expected raw 'li1e2:xye' got '%s'", string(v.Inner.Raw))
+	}
+	if v.Announce != "foo" {
+		t.Fatalf("Expected announce 'foo' got '%s'", v.Announce)
+	}
+}
+
+func TestUnmarshalingWithStringOption(t *testing.T) {
+	type T struct {
+		Port  uint16 `bencoding:"port,string"`
+		Delta *int   `bencoding:"delta,string"`
+	}
+	var v T
+	if e := Unmarshal([]byte("d5:delta2:-34:port4:6881e"), &v); e != nil {
+		t.Fatal(e)
+	} else if v.Port != 6881 || v.Delta == nil || *v.Delta != -3 {
+		t.Fatalf("Expected {6881 -3} got '%v'", v)
+	}
+	if e := Unmarshal([]byte("d4:porti6881ee"), &v); e == nil {
+		t.Fatalf("Expected error for integer in string encoded field")
+	}
+	if e := Unmarshal([]byte("d4:port5:99999e"), &v); e == nil {
+		t.Fatalf("Expected error for out of range port")
+	}
+}
+
+func TestUnmarshalingRequiredFields(t *testing.T) {
+	type T struct {
+		Name   string `bencoding:"name,required"`
+		Length int64  `bencoding:"length,omitempty"`
+	}
+	var v T
+	if e := Unmarshal([]byte("d4:name3:fooe"), &v); e != nil {
+		t.Fatal(e)
+	} else if v.Name != "foo" {
+		t.Fatalf("Expected name 'foo' got '%v'", v.Name)
+	}
+	var me *MissingFieldError
+	if e := Unmarshal([]byte("d6:lengthi1ee"), &v); !errors.As(e, &me) || me.Field != "name" || me.Offset != 13 {
+		t.Fatalf("Expected error for missing required field, got %v", e)
+	}
+	var nested struct {
+		Inner []T `bencoding:"inner"`
+	}
+	if e := Unmarshal([]byte("d5:innerlde4:name1:xeee"), &nested); !errors.As(e, &me) || me.Field != "inner[0].name" {
+		t.Fatalf("Expected error for missing 'inner[0].name', got %v", e)
+	}
+}
+
+func TestUnmarshalingWithDisallowedUnknownFields(t *testing.T) {
+	type Info struct {
+		Name string `bencoding:"name"`
+	}
+	type T struct {
+		Announce string `bencoding:"announce"`
+		Info     Info   `bencoding:"info"`
+		Ignored  string `bencoding:"-"`
+	}
+	s := "d8:announce3:foo4:infod6:lengthi1e4:name3:baree"
+	var v T
+	if e := NewStringDecoder(s).Decode(&v); e != nil {
+		t.Fatal(e)
+	}
+	d := NewStringDecoder(s)
+	d.DisallowUnknownFields()
+	var ue *UnknownFieldError
+	if e := d.Decode(&v); !errors.As(e, &ue) || ue.Field != "info.length" || ue.Offset != 23 {
+		t.Fatalf("Expected error for unknown field 'info.length' at offset 23, got '%v'", e)
+	}
+	d = NewStringDecoder("d1:-3:fooe")
+	d.DisallowUnknownFields()
+	if e := d.Decode(&v); e == nil {
+		t.Fatalf("Expected error for key of ignored field")
+	}
+	d = NewStringDecoder("d8:announce3:fooe")
+	d.DisallowUnknownFields()
+	if e := d.Decode(&v); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestUnmarshalingNonCanonicalInput(t *testing.T) {
+	type Info struct {
+		Length int64  `bencoding:"length"`
+		Name   string `bencoding:"name"`
+	}
+	type T struct {
+		Info Info       `bencoding:"info"`
+		Raw  RawMessage `bencoding:"raw"`
+	}
+	valid := []string{
+		"d4:infod6:lengthi0e4:name3:fooee",
+		"d4:infod6:lengthi-10e4:name0:ee",
+		"d4:infod0:i1e6:lengthi10ee3:rawd1:ai1e1:bi2eee",
+	}
+	for _, s := range valid {
+		var v T
+		d := NewStringDecoder(s)
+		d.DisallowNonCanonical()
+		if e := d.Decode(&v); e != nil {
+			t.Fatalf("Expected '%s' to be canonical, got '%v'", s, e)
+		}
+	}
+	invalid := []string{
+		"d4:infod6:lengthi-0e4:name3:fooee",
+		"d4:infod6:lengthi03e4:name3:fooee",
+		"d4:infod6:lengthi+3e4:name3:fooee",
+		"d4:infod6:lengthi1e4:name03:fooee",
+		"d4:infod4:name3:foo6:lengthi1eee",
+		"d4:infod4:name3:foo4:name3:baree",
+		"d3:rawd1:bi1e1:ai2ee4:infodee",
+		"d3:rawli01eee",
+		"d3:rawd1:ai1e1:ai2eee",
+	}
+	for _, s := range invalid {
+		var v T
+		if e := NewStringDecoder(s).Decode(&v); e != nil {
+			t.Fatalf("Expected '%s' to be accepted in default mode, got '%v'", s, e)
+		}
+		d := NewStringDecoder(s)
+		d.DisallowNonCanonical()
+		if e := d.Decode(&v); e == nil {
+			t.Fatalf("Expected '%s' to be rejected as non-canonical", s)
+		}
+	}
+}
+
+func TestUnmarshalSyntaxErrorsHaveOffsets(t *testing.T) {
+	data := []struct {
+		in     string
+		offset int64
+	}{
+		{"d4:infod6:lengthi1x2eee", 16},
+		{"d4:infod6:lengthi12", 19},
+		{"l3:foo", 6},
+		{"l3:fooi1ex", 9},
+		{"d4:infoi1ei3ei4ee", 10},
+		{"d3:foo10:short", 14},
+	}
+	for _, test := range data {
+		var v interface{}
+		var se *SyntaxError
+		if e := Unmarshal([]byte(test.in), &v); !errors.As(e, &se) {
+			t.Fatalf("Expected SyntaxError for '%s', got '%v'", test.in, e)
+		} else if se.Offset != test.offset {
+			t.Fatalf("Expected offset %d for '%s', got %d (%v)", test.offset, test.in, se.Offset, se)
+		}
+	}
+}
+
+func TestUnmarshalInvalidCharactersAreSyntaxErrors(t *testing.T) {
+	type File struct {
+		Length int64 `bencoding:"length"`
+	}
+	type T struct {
+		Files []File `bencoding:"files"`
+	}
+	var i int
+	var s string
+	var m map[string]int
+	var v T
+	for _, test := range []struct {
+		in     string
+		v      interface{}
+		offset int64
+		field  string
+	}{
+		{"x", &i, 0, ""},
+		{"x", &s, 0, ""},
+		{"x", &m, 0, ""},
+		{"d5:filesld6:lengthi1eed6:lengthi2eexee", &v, 34, "files[2]"},
+	} {
+		var se *SyntaxError
+		if e := Unmarshal([]byte(test.in), test.v); !errors.As(e, &se) {
+			t.Fatalf("Expected SyntaxError for '%s', got '%v'", test.in, e)
+		} else if se.Offset != test.offset || se.Field != test.field {
+			t.Fatalf("Expected error in '%s' at %d for '%s', got '%v'", test.field, test.offset, test.in, se)
+		}
+	}
+}
+
+func TestUnmarshalTypeErrors(t *testing.T) {
+	type Info struct {
+		Length int64 `bencoding:"length"`
+	}
+	type T struct {
+		Name string `bencoding:"name"`
+		Info Info   `bencoding:"info"`
+	}
+	data := []struct {
+		in     string
+		value  string
+		field  string
+		offset int64
+	}{
+		{"d4:namei1ee", "integer", "name", 7},
+		{"d4:infod6:length3:fooee", "string", "info.length", 16},
+		{"le", "list", "", 0},
+	}
+	for _, test := range data {
+		var v T
+		var te *UnmarshalTypeError
+		if e := Unmarshal([]byte(test.in), &v); !errors.As(e, &te) {
+			t.Fatalf("Expected UnmarshalTypeError for '%s', got '%v'", test.in, e)
+		} else if te.Value != test.value || te.Field != test.field || te.Offset != test.offset {
+			t.Fatalf("Expected %s in %s at %d for '%s', got '%v'", test.value, test.field, test.offset, test.in, te)
+		}
+	}
+	var i int8
+	var te *UnmarshalTypeError
+	if e := Unmarshal([]byte("i300e"), &i); !errors.As(e, &te) || te.Value != "integer 300" {
+		t.Fatalf("Expected UnmarshalTypeError for integer 300, got '%v'", e)
+	}
+	var u uint
+	for _, in := range []string{"i-5e", "i-99999999999999999999e"} {
+		if e := Unmarshal([]byte(in), &u); !errors.As(e, &te) || te.Value != "integer "+in[1:len(in)-1] {
+			t.Fatalf("Expected UnmarshalTypeError for '%s' into uint, got '%v'", in, e)
+		}
+	}
+	var se *SyntaxError
+	if e := Unmarshal([]byte("i-x5e"), &u); !errors.As(e, &se) {
+		t.Fatalf("Expected SyntaxError for 'i-x5e', got '%v'", e)
+	}
+	var b []byte
+	if e := Unmarshal([]byte("le"), &b); !errors.As(e, &te) || te.Value != "list" || te.Type.String() != "[]uint8" {
+		t.Fatalf("Expected UnmarshalTypeError for list into []uint8, got '%v'", e)
+	}
+}
+
+func TestUnmarshalInvalidArguments(t *testing.T) {
+	var i int
+	var p *int
+	for _, v := range []interface{}{nil, i, p} {
+		var ie *InvalidUnmarshalError
+		if e := Unmarshal([]byte("i1e"), v); !errors.As(e, &ie) {
+			t.Fatalf("Expected InvalidUnmarshalError for %T, got '%v'", v, e)
+		}
+	}
+}
+
+func TestDecodingEmptyInputReturnsEOF(t *testing.T) {
+	var i int
+	d := NewStringDecoder("i1e")
+	if e := d.Decode(&i); e != nil {
+		t.Fatal(e)
+	}
+	if e := d.Decode(&i); e != io.EOF {
+		t.Fatalf("Expected io.EOF at end of input, got '%v'", e)
+	}
+}
+
+func TestUnmarshalErrorsInListsAreReported(t *testing.T) {
+	type File struct {
+		Length int64    `bencoding:"length"`
+		Path   []string `bencoding:"path"`
+	}
+	type Info struct {
+		Files []File `bencoding:"files"`
+	}
+	type T struct {
+		Info Info `bencoding:"info"`
+	}
+	data := []struct {
+		in    string
+		field string
+	}{
+		{"d4:infod5:filesld6:lengthi1e4:pathl1:aeed6:lengthi2e4:pathl1:", "info.files[1].path[0]"},
+		{"d4:infod5:filesld6:lengthi1e4:pathl1:aeed6:length1:x4:pathl1:beeeee", "info.files[1].length"},
+		{"d4:infod5:filesld6:lengthi1e4:pathl1:aeed6:lengthi2e4:pathli1eeeeeee", "info.files[1].path[0]"},
+	}
+	for _, test := range data {
+		var v T
+		e := Unmarshal([]byte(test.in), &v)
+		var se *SyntaxError
+		var te *UnmarshalTypeError
+		if errors.As(e, &se) {
+			if se.Field != test.field {
+				t.Fatalf("Expected error in '%s' for '%s', got '%v'", test.field, test.in, e)
+			}
+		} else if errors.As(e, &te) {
+			if te.Field != test.field {
+				t.Fatalf("Expected error in '%s' for '%s', got '%v'", test.field, test.in, e)
+			}
+		} else {
+			t.Fatalf("Expected error in '%s' for '%s', got '%v'", test.field, test.in, e)
+		}
+	}
+	var v T
+	s := "d4:infod5:filesld6:lengthi1e4:pathl1:aeed6:lengthi2e4:pathl1:b1:ceeeee"
+	if e := Unmarshal([]byte(s), &v); e != nil {
+		t.Fatal(e)
+	} else if len(v.Info.Files) != 2 || v.Info.Files[1].Length != 2 || len(v.Info.Files[1].Path) != 2 || v.Info.Files[1].Path[1] != "c" {
+		t.Fatalf("Did not expect to get '%v'", v)
+	}
+}
+
+func TestUnmarshalErrorsBehindPointersAreReported(t *testing.T) {
+	type T struct {
+		P *[]int `bencoding:"p"`
+	}
+	var v T
+	var te *UnmarshalTypeError
+	if e := Unmarshal([]byte("d1:pli1e3:fooee"), &v); !errors.As(e, &te) || te.Field != "p[1]" {
+		t.Fatalf("Expected type error in 'p[1]', got '%v'", e)
+	}
+}
+
+func TestUnmarshalTypedMapsAndLists(t *testing.T) {
+	var m map[string][]int
+	if e := Unmarshal([]byte("d1:ali1ei2ee1:blee"), &m); e != nil {
+		t.Fatal(e)
+	} else if len(m) != 2 || len(m["a"]) != 2 || m["a"][1] != 2 || len(m["b"]) != 0 {
+		t.Fatalf("Did not expect to get '%v'", m)
+	}
+	var te *UnmarshalTypeError
+	if e := Unmarshal([]byte("d1:ali1e1:xee"), &m); !errors.As(e, &te) || te.Field != "a[1]" {
+		t.Fatalf("Expected type error in 'a[1]', got '%v'", e)
+	}
+}
+
+func TestUnmarshalIgnoresUnexportedFields(t *testing.T) {
+	type T struct {
+		Name   string `bencoding:"name"`
+		hidden string
+	}
+	var v T
+	if e := Unmarshal([]byte("d6:hidden3:foo4:name3:bare"), &v); e != nil {
+		t.Fatal(e)
+	} else if v.Name != "bar" || v.hidden != "" {
+		t.Fatalf("Did not expect to get '%v'", v)
+	}
+}
+
+func BenchmarkUnmarshalTorrent(b *testing.B) {
+	type File struct {
+		Length int64    `bencoding:"length"`
+		Path   []string `bencoding:"path"`
+	}
+	type Info struct {
+		Files       []File `bencoding:"files"`
+		Length      int64  `bencoding:"length"`
+		Name        string `bencoding:"name"`
+		PieceLength int64  `bencoding:"piece length"`
+		Pieces      []byte `bencoding:"pieces"`
+	}
+	type Torrent struct {
+		Announce     string     `bencoding:"announce"`
+		AnnounceList [][]string `bencoding:"announce-list"`
+		Comment      string     `bencoding:"comment"`
+		CreationDate int64      `bencoding:"creation date"`
+		Info         Info       `bencoding:"info"`
+	}
+	data, e := ioutil.ReadFile("data/debian-7.1.0-amd64-DVD-1.iso.torrent")
+	if e != nil {
+		b.Fatal(e)
+	}
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		var t Torrent
+		if _, e := UnmarshalTorrent(data, &t); e != nil {
+			b.Fatal(e)
+		}
+	}
+}
+
+func TestDecoderExposesRemainingInput(t *testing.T) {
+	type Message struct {
+		MsgType   int64 `bencoding:"msg_type"`
+		Piece     int64 `bencoding:"piece"`
+		TotalSize int64 `bencoding:"total_size"`
+	}
+	header := "d8:msg_typei1e5:piecei0e10:total_sizei8ee"
+	r := strings.NewReader(header + "metadata")
+	d := NewDecoder(r)
+	var m Message
+	if e := d.Decode(&m); e != nil {
+		t.Fatal(e)
+	}
+	if off := d.InputOffset(); off != int64(len(header)) {
+		t.Fatalf("Expected offset %d, got %d", len(header), off)
+	}
+	if d.More() != true {
+		t.Fatalf("Expected more input after the dictionary")
+	}
+	rest, e := ioutil.ReadAll(io.MultiReader(d.Buffered(), r))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(rest) != "metadata" || int64(len(rest)) != m.TotalSize {
+		t.Fatalf("Expected 'metadata', got '%s'", string(rest))
+	}
+}
+
+func TestDecoderRejectsHugeStringLength(t *testing.T) {
+	d := NewDecoder(strings.NewReader("99999999999:abc"))
+	d.SetMaxStringLength(1 << 20)
+	var s string
+	var le *StringLengthError
+	if e := d.Decode(&s); !errors.As(e, &le) {
+		t.Fatalf("Expected StringLengthError, got %v", e)
+	} else if le.Length != 99999999999 || le.Offset != 0 {
+		t.Fatalf("Unexpected error details: %+v", le)
+	}
+}
+
+func TestDecoderDoesNotTrustStringLength(t *testing.T) {
+	var s []byte
+	if e := Unmarshal([]byte("99999999999:abc"), &s); e == nil {
+		t.Fatalf("Expected error for truncated string")
+	}
+}
+
+func TestDecoderRejectsLongDigitRuns(t *testing.T) {
+	var i int64
+	input := "i" + strings.Repeat("1", 1<<16) + "e"
+	var se *SyntaxError
+	if e := Unmarshal([]byte(input), &i); !errors.As(e, &se) {
+		t.Fatalf("Expected SyntaxError, got %v", e)
+	}
+}
+
+func TestDecoderRejectsDeepNesting(t *testing.T) {
+	input := strings.Repeat("l", 100) + strings.Repeat("e", 100)
+	for _, name := range []string{"decode", "skip", "token"} {
+		d := NewDecoder(strings.NewReader(input))
+		d.SetMaxDepth(50)
+		var e error
+		switch name {
+		case "decode":
+			var v interface{}
+			e = d.Decode(&v)
+		case "skip":
+			e = d.Skip()
+		case "token":
+			for e == nil {
+				_, e = d.Token()
+			}
+		}
+		var de *DepthError
+		if !errors.As(e, &de) {
+			t.Fatalf("%s: expected DepthError, got %v", name, e)
+		} else if de.Limit != 50 || de.Offset != 51 {
+			t.Fatalf("%s: unexpected error details: %+v", name, de)
+		}
+	}
+
+	var v interface{}
+	if e := Unmarshal([]byte(input), &v); e != nil {
+		t.Fatalf("Expected default depth limit to allow input, got %v", e)
+	}
+}
+
+func TestDecoderLimitsInputSize(t *testing.T) {
+	d := NewDecoder(strings.NewReader("li1ei2ei3ee"))
+	d.SetMaxBytes(8)
+	var l []int64
+	var se *InputSizeError
+	if e := d.Decode(&l); !errors.As(e, &se) {
+		t.Fatalf("Expected InputSizeError, got %v", e)
+	}
+
+	d = NewDecoder(strings.NewReader("5:abcde"))
+	d.SetMaxBytes(4)
+	var s string
+	if e := d.Decode(&s); !errors.As(e, &se) || se.Limit != 4 {
+		t.Fatalf("Expected InputSizeError, got %v", e)
+	}
+
+	d = NewDecoder(strings.NewReader("li1ei2ei3ee"))
+	d.SetMaxBytes(11)
+	if e := d.Decode(&l); e != nil {
+		t.Fatalf("Expected input within limit to decode, got %v", e)
+	}
+}
+
+func TestDecoderLimitsElementCount(t *testing.T) {
+	d := NewDecoder(strings.NewReader("d1:ai1e1:bli1ei2eee"))
+	d.SetMaxElements(3)
+	var v interface{}
+	var ce *ElementCountError
+	if e := d.Decode(&v); !errors.As(e, &ce) || ce.Limit != 3 {
+		t.Fatalf("Expected ElementCountError, got %v", e)
+	}
+
+	d = NewDecoder(strings.NewReader("li1eeli2ee"))
+	d.SetMaxElements(1)
+	var l []int64
+	if e := d.Decode(&l); e != nil {
+		t.Fatal(e)
+	}
+	if e := d.Decode(&l); !errors.As(e, &ce) {
+		t.Fatalf("Expected limit to span decoder lifetime, got %v", e)
+	}
+}
+
+func TestTorrentDecoderHashesOnlyTopLevelInfo(t *testing.T) {
+	input := "d4:metad4:infoi1ee4:infod4:name1:xee"
+	d := NewBytesTorrentDecoder([]byte(input))
+	hash, e := d.Decode(&struct{}{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	span := d.HashedSpan()
+	if hashed := input[span.Start:span.End]; hashed != "d4:name1:xe" {
+		t.Fatalf("Expected top level info to be hashed, got '%s'", hashed)
+	}
+	if sum := sha1.Sum([]byte("d4:name1:xe")); hash != InfoHash(sum[:]) {
+		t.Fatalf("Unexpected hash %x", hash.Hex())
+	}
+
+	if _, e := UnmarshalTorrent([]byte("d4:metad4:infoi1eee"), &struct{}{}); e == nil {
+		t.Fatalf("Expected error for missing top level info")
+	}
+}
+
+func TestTorrentDecoderHashesConfiguredPath(t *testing.T) {
+	input := "d4:metad4:infoi1ee4:infoi2ee"
+	d := NewBytesTorrentDecoder([]byte(input))
+	d.SetHashedKeyPath("meta", "info")
+	d.SetHashFunc(sha256.New)
+	hash, e := d.Decode(&struct{}{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if sum := sha256.Sum256([]byte("i1e")); hash != InfoHash(sum[:]) {
+		t.Fatalf("Unexpected hash %x", hash.Hex())
+	}
+	if span := d.HashedSpan(); span != (Span{14, 17}) {
+		t.Fatalf("Unexpected span %v", span)
+	}
+
+	d = NewBytesTorrentDecoder([]byte(input))
+	d.SetHashedKeyPath()
+	if hash, e = d.Decode(&struct{}{}); e != nil {
+		t.Fatal(e)
+	}
+	if sum := sha1.Sum([]byte(input)); hash != InfoHash(sum[:]) {
+		t.Fatalf("Expected hash of the whole input, got %x", hash.Hex())
+	}
+}
+
+type keyCount int
+
+func (c *keyCount) UnmarshalBencode(b []byte) error {
+	var m map[string]RawMessage
+	if e := Unmarshal(b, &m); e != nil {
+		return e
+	}
+	*c = keyCount(len(m))
+	return nil
+}
+
+func TestTorrentDecoderHashesInsideRawValues(t *testing.T) {
+	var raw RawMessage
+	hash, e := UnmarshalTorrent([]byte("d4:infoi2ee"), &raw)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if sum := sha1.Sum([]byte("i2e")); hash != InfoHash(sum[:]) || string(raw) != "d4:infoi2ee" {
+		t.Fatalf("Unexpected hash %x of '%s'", hash.Hex(), raw)
+	}
+
+	var count keyCount
+	if hash, e = UnmarshalTorrent([]byte("d1:ai1e4:infod1:xi1eee"), &count); e != nil {
+		t.Fatal(e)
+	}
+	if sum := sha1.Sum([]byte("d1:xi1ee")); hash != InfoHash(sum[:]) || count != 2 {
+		t.Fatalf("Unexpected hash %x or count %d", hash.Hex(), count)
+	}
+
+	input := "d4:metad1:li1e4:infoi1eee"
+	var v struct {
+		Meta RawMessage `bencoding:"meta"`
+	}
+	d := NewBytesTorrentDecoder([]byte(input))
+	d.SetHashedKeyPath("meta", "info")
+	if hash, e = d.Decode(&v); e != nil {
+		t.Fatal(e)
+	}
+	if sum := sha1.Sum([]byte("i1e")); hash != InfoHash(sum[:]) || d.HashedSpan() != (Span{20, 23}) {
+		t.Fatalf("Unexpected hash %x or span %v", hash.Hex(), d.HashedSpan())
+	}
+
+	var me *MissingFieldError
+	if _, e := UnmarshalTorrent([]byte("ld4:infoi1eee"), &raw); !errors.As(e, &me) || me.Field != "info" {
+		t.Fatalf("Expected info inside a list not to be hashed, got %v", e)
+	}
+	var se *SyntaxError
+	if _, e := UnmarshalTorrent([]byte("d4:infoi1e4:infoi2ee"), &raw); !errors.As(e, &se) {
+		t.Fatalf("Expected SyntaxError for duplicate info, got %v", e)
+	}
+}
+
+func TestTorrentDecoderRejectsDuplicateHashedKey(t *testing.T) {
+	var se *SyntaxError
+	if _, e := UnmarshalTorrent([]byte("d4:infoi1e4:infoi2ee"), &struct{}{}); !errors.As(e, &se) {
+		t.Fatalf("Expected SyntaxError, got %v", e)
+	}
+
+	d := NewDecoder(strings.NewReader("d4:infoi1eed4:infoi2ee"))
+	for i := 0; i < 2; i++ {
+		if e := d.Decode(&struct{}{}); e != nil {
+			t.Fatalf("Expected separate values to be independent, got %v", e)
+		}
+	}
+}
+
+func TestTorrentDecoderComputesBothInfoHashes(t *testing.T) {
+	input := "d8:announce3:url4:infod4:name1:x12:meta versioni2eee"
+	info := []byte("d4:name1:x12:meta versioni2ee")
+	v1, v2, e := UnmarshalTorrentHashes([]byte(input), &struct{}{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if sum := sha1.Sum(info); v1 != InfoHashV1(sum) {
+		t.Fatalf("Unexpected v1 hash %v", v1)
+	}
+	if sum := sha256.Sum256(info); v2 != InfoHashV2(sum) {
+		t.Fatalf("Unexpected v2 hash %v", v2)
+	}
+	if _, _, e := UnmarshalTorrentHashes([]byte("d8:announce3:urle"), &struct{}{}); e == nil {
+		t.Fatalf("Expected error for missing info")
+	}
+}
@@ -0,0 +1,105 @@
+package bencoding
+
+import "errors"
+
+// An Editor changes the top level entries of a bencoded dictionary
+// while keeping everything it is not asked to change as the original
+// bytes. Editing the announce list of a torrent therefore leaves the
+// info dictionary, and so the info hash, untouched even when the
+// input is not in canonical form.
+//
+// Errors are sticky: once a call fails, later calls do nothing and
+// Bytes returns the first error.
+type Editor struct {
+	entries []editEntry
+	err     error
+}
+
+type editEntry struct {
+	key      string
+	rawKey   []byte
+	rawValue []byte
+}
+
+// Edit starts editing the dictionary encoded in data.
+func Edit(data []byte) *Editor {
+	var v Value
+	if e := Unmarshal(data, &v); e != nil {
+		return &Editor{err: e}
+	}
+	if v.Kind != Dict {
+		return &Editor{err: errors.New("can't edit " + v.Kind.String() + ", expected dictionary")}
+	}
+	ed := &Editor{entries: make([]editEntry, 0, len(v.Dict))}
+	for _, entry := range v.Dict {
+		ed.entries = append(ed.entries, editEntry{
+			key:      entry.Key,
+			rawKey:   data[entry.KeySpan.Start:entry.KeySpan.End],
+			rawValue: data[entry.Value.Span.Start:entry.Value.Span.End],
+		})
+	}
+	return ed
+}
+
+// Set replaces the value of the first entry with the given key with
+// the encoding of v, as returned by Marshal. A new key is inserted
+// before the first key sorting after it, which keeps a sorted
+// dictionary sorted.
+func (ed *Editor) Set(key string, v interface{}) *Editor {
+	if ed.err != nil {
+		return ed
+	}
+	raw, e := Marshal(v)
+	if e != nil {
+		ed.err = e
+		return ed
+	}
+	for i := range ed.entries {
+		if ed.entries[i].key == key {
+			ed.entries[i].rawValue = raw
+			return ed
+		}
+	}
+	entry := editEntry{key: key, rawKey: appendString(nil, []byte(key)), rawValue: raw}
+	i := 0
+	for i < len(ed.entries) && ed.entries[i].key < key {
+		i++
+	}
+	ed.entries = append(ed.entries, editEntry{})
+	copy(ed.entries[i+1:], ed.entries[i:])
+	ed.entries[i] = entry
+	return ed
+}
+
+// Delete removes every entry with the given key.
+func (ed *Editor) Delete(key string) *Editor {
+	if ed.err != nil {
+		return ed
+	}
+	kept := ed.entries[:0]
+	for _, entry := range ed.entries {
+		if entry.key != key {
+			kept = append(kept, entry)
+		}
+	}
+	ed.entries = kept
+	return ed
+}
+
+// Bytes returns the edited dictionary.
+func (ed *Editor) Bytes() ([]byte, error) {
+	if ed.err != nil {
+		return nil, ed.err
+	}
+	size := 2
+	for _, entry := range ed.entries {
+		size += len(entry.rawKey) + len(entry.rawValue)
+	}
+	out := make([]byte, 0, size)
+	out = append(out, 'd')
+	for _, entry := range ed.entries {
+		out = append(out, entry.rawKey...)
+		out = append(out, entry.rawValue...)
+	}
+	return append(out, 'e'), nil
+}
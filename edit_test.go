@@ -0,0 +1,67 @@
+package bencoding
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestEditKeepsUntouchedEntriesByteIdentical(t *testing.T) {
+	// The info dictionary is deliberately not canonical: its keys
+	// are unsorted and the length uses a leading zero.
+	input := "d8:announce3:old7:comment2:hi4:infod4:name1:x6:lengthi1e03:abci2eee"
+	out, e := Edit([]byte(input)).
+		Set("announce", "new").
+		Delete("comment").
+		Set("announce-list", [][]string{{"a", "b"}}).
+		Bytes()
+	if e != nil {
+		t.Fatal(e)
+	}
+	expected := "d8:announce3:new13:announce-listll1:a1:bee4:infod4:name1:x6:lengthi1e03:abci2eee"
+	if string(out) != expected {
+		t.Fatalf("Expected '%s', got '%s'", expected, string(out))
+	}
+}
+
+func TestEditWithoutChangesReturnsInput(t *testing.T) {
+	data, e := ioutil.ReadFile("data/debian-7.1.0-amd64-DVD-1.iso.torrent")
+	if e != nil {
+		t.Fatal(e)
+	}
+	out, e := Edit(data).Bytes()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("Expected unchanged torrent")
+	}
+
+	out, e = Edit(data).Set("comment", "edited").Bytes()
+	if e != nil {
+		t.Fatal(e)
+	}
+	orig, e := UnmarshalTorrent(data, &struct{}{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	edited, e := UnmarshalTorrent(out, &struct{}{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if orig != edited {
+		t.Fatalf("Expected info hash to be unchanged")
+	}
+}
+
+func TestEditReportsErrors(t *testing.T) {
+	if _, e := Edit([]byte("li1ee")).Set("a", 1).Bytes(); e == nil {
+		t.Fatalf("Expected error for editing a list")
+	}
+	if _, e := Edit([]byte("d1:a")).Delete("a").Bytes(); e == nil {
+		t.Fatalf("Expected error for truncated input")
+	}
+	if _, e := Edit([]byte("de")).Set("a", func() {}).Bytes(); e == nil {
+		t.Fatalf("Expected error for value that can't be marshaled")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"sort"
 	"strconv"
+	"sync"
 )
 
 // Marshal returns bencode encoding of v.
@@ -30,10 +31,22 @@ import (
 //
 // Structs are encoded as dictionaries. Each exported field becomes
 // a member of dictionary unless
-//  - the field's bencoding tag is "" or "-"
+//   - the field's bencoding tag is "" or "-"
+//   - the field's tag has the "omitempty" option and the field
+//     holds an empty value: zero number, empty string, slice,
+//     array or map, or a nil pointer or interface
+//
+// The key of the field's entry is the name given in its bencoding
+// tag, or the field name itself when the tag does not give one
+// (for example `bencoding:",omitempty"`). The "string" option
+// encodes an integer field as a bencode string holding its
+// decimal representation.
 //
 // Pointers are encoded as values to which they point.
 //
+// If a value implements the Marshaler interface, Marshal calls
+// its MarshalBencode method and writes the returned bytes verbatim.
+//
 // Any other type is not supported and trying to encode it will result in an error.
 func Marshal(v interface{}) ([]byte, error) {
 	var e encodeState
@@ -43,9 +56,18 @@ func Marshal(v interface{}) ([]byte, error) {
 	return e.Bytes(), nil
 }
 
+// Marshaler is the interface implemented by types that
+// can marshal themselves into valid bencode.
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
 type Encoder struct {
-	w io.Writer
-	e encodeState
+	w     io.Writer
+	e     encodeState
+	state []writerLevel
 }
 
 func NewEncoder(w io.Writer) *Encoder {
@@ -53,6 +75,9 @@ func NewEncoder(w io.Writer) *Encoder {
 }
 
 func (enc *Encoder) Encode(v interface{}) error {
+	if err := enc.beginValue(); err != nil {
+		return err
+	}
 	enc.e.Reset()
 	if err := enc.e.Marshal(v); err != nil {
 		return err
@@ -71,28 +96,119 @@ func (e *encodeState) Marshal(v interface{}) error {
 }
 
 func (e *encodeState) marshal(val reflect.Value) error {
-	switch val.Kind() {
+	if !val.IsValid() {
+		return errors.New("Unknown kind: " + val.Kind().String())
+	}
+	return typeEncoder(val.Type())(e, val)
+}
+
+// encoderFunc writes the bencoding of a value of a particular type.
+type encoderFunc func(e *encodeState, val reflect.Value) error
+
+var encoderCache sync.Map // map[reflect.Type]encoderFunc
+
+// typeEncoder returns the cached encoder for values of type t.
+func typeEncoder(t reflect.Type) encoderFunc {
+	if fi, ok := encoderCache.Load(t); ok {
+		return fi.(encoderFunc)
+	}
+
+	// To deal with recursive types, populate the map with an
+	// indirect func before we build it. This type waits on the
+	// real func (f) to be ready and then calls it. This indirect
+	// func is only used for recursive types.
+	var (
+		wg sync.WaitGroup
+		f  encoderFunc
+	)
+	wg.Add(1)
+	fi, loaded := encoderCache.LoadOrStore(t, encoderFunc(func(e *encodeState, val reflect.Value) error {
+		wg.Wait()
+		return f(e, val)
+	}))
+	if loaded {
+		return fi.(encoderFunc)
+	}
+
+	f = newTypeEncoder(t, true)
+	wg.Done()
+	encoderCache.Store(t, f)
+	return f
+}
+
+// newTypeEncoder constructs an encoderFunc for a type.
+// The returned encoder only checks CanAddr when allowAddr is true.
+func newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
+	if t.Implements(marshalerType) {
+		return marshalerEncoder(newKindEncoder(t))
+	}
+	if t.Kind() != reflect.Ptr && allowAddr && reflect.PtrTo(t).Implements(marshalerType) {
+		return addrMarshalerEncoder(newTypeEncoder(t, false))
+	}
+	return newKindEncoder(t)
+}
+
+func newKindEncoder(t reflect.Type) encoderFunc {
+	switch t.Kind() {
 	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
-		return e.marshalInt(val)
+		return (*encodeState).marshalInt
 	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
-		return e.marshalUnsignedInt(val)
+		return (*encodeState).marshalUnsignedInt
 	case reflect.String:
-		return e.marshalString(val)
+		return (*encodeState).marshalString
 	case reflect.Slice:
-		return e.marshalSlice(val)
+		return (*encodeState).marshalSlice
 	case reflect.Array:
-		return e.marshalArray(val)
+		return (*encodeState).marshalArray
 	case reflect.Map:
-		return e.marshalMap(val)
+		return (*encodeState).marshalMap
 	case reflect.Struct:
-		return e.marshalStruct(val)
+		return newStructEncoder(t)
 	case reflect.Ptr:
-		return e.marshalPtr(val)
+		return (*encodeState).marshalPtr
 	case reflect.Interface:
-		return e.marshalInterface(val)
+		return (*encodeState).marshalInterface
 	default:
-		return errors.New("Unknown kind: " + val.Kind().String())
+		kind := t.Kind().String()
+		return func(e *encodeState, val reflect.Value) error {
+			return errors.New("Unknown kind: " + kind)
+		}
+	}
+}
+
+// marshalerEncoder calls MarshalBencode on values implementing
+// Marshaler, falling back to fallback for nil pointers and
+// values which can not be converted to an interface.
+func marshalerEncoder(fallback encoderFunc) encoderFunc {
+	return func(e *encodeState, val reflect.Value) error {
+		if (val.Kind() == reflect.Ptr && val.IsNil()) || !val.CanInterface() {
+			return fallback(e, val)
+		}
+		return e.marshalMarshaler(val.Interface().(Marshaler))
+	}
+}
+
+// addrMarshalerEncoder calls MarshalBencode on the address of
+// addressable values whose pointer type implements Marshaler.
+func addrMarshalerEncoder(fallback encoderFunc) encoderFunc {
+	return func(e *encodeState, val reflect.Value) error {
+		if !val.CanAddr() || !val.Addr().CanInterface() {
+			return fallback(e, val)
+		}
+		return e.marshalMarshaler(val.Addr().Interface().(Marshaler))
+	}
+}
+
+func (e *encodeState) marshalMarshaler(m Marshaler) error {
+	b, err := m.MarshalBencode()
+	if err != nil {
+		return err
+	}
+	if !isValidBencode(b) {
+		return errors.New("MarshalBencode of " + reflect.TypeOf(m).String() + " returned invalid bencode")
 	}
+	_, err = e.Write(b)
+	return err
 }
 
 func (e *encodeState) marshalInt(val reflect.Value) error {
@@ -157,10 +273,9 @@ func (e *encodeState) marshalCollectionAsList(val reflect.Value) error {
 	if err := e.WriteByte('l'); err != nil {
 		return err
 	}
+	enc := typeEncoder(val.Type().Elem())
 	for i := 0; i < val.Len(); i++ {
-		// array of interface{} values, need to extract unterling type of element
-		element := reflect.ValueOf(val.Index(i).Interface())
-		if err := e.marshal(element); err != nil {
+		if err := enc(e, val.Index(i)); err != nil {
 			return err
 		}
 	}
@@ -203,83 +318,122 @@ func (this sortableByteSliceSlice) Swap(i, j int) {
 }
 
 func (e *encodeState) marshalMap(val reflect.Value) error {
+	if val.Type().Key().Kind() != reflect.String {
+		return errors.New("Map can be marshaled only if keys are of type 'string'")
+	}
 	keys := val.MapKeys()
 	if err := e.WriteByte('d'); err != nil {
 		return err
 	}
 
 	rawKeys := make(sortableByteSliceSlice, len(keys))
-
 	for i, key := range keys {
-		if key.Kind() != reflect.String {
-			return errors.New("Map can be marshaled only if keys are of type 'string'")
-		}
 		rawKeys[i] = []byte(key.String())
 	}
 
 	sort.Sort(rawKeys)
 
+	keyType := val.Type().Key()
+	enc := typeEncoder(val.Type().Elem())
 	for _, rawKey := range rawKeys {
-		key := string(rawKey)
-		vKey := reflect.ValueOf(key)
-		if err := e.marshal(vKey); err != nil {
+		if err := e.writeString(rawKey); err != nil {
 			return err
 		}
-		value := val.MapIndex(vKey)
-		if err := e.marshal(value); err != nil {
+		value := val.MapIndex(reflect.ValueOf(string(rawKey)).Convert(keyType))
+		if err := enc(e, value); err != nil {
 			return err
 		}
 	}
 	return e.WriteByte('e')
 }
 
-type positionedField struct {
-	name []byte
-	pos  int
-}
-
-type positionedFieldsByName []positionedField
-
-func (f positionedFieldsByName) Len() int {
-	return len(f)
-}
-
-func (f positionedFieldsByName) Less(i, j int) bool {
-	return bytes.Compare(f[i].name, f[j].name) == -1
+// structEncoder writes structs as dictionaries using
+// the cached fields of the struct type.
+type structEncoder struct {
+	fields    []field
+	fieldEncs []encoderFunc
 }
 
-func (f positionedFieldsByName) Swap(i, j int) {
-	tmp := f[i]
-	f[i] = f[j]
-	f[j] = tmp
+func newStructEncoder(t reflect.Type) encoderFunc {
+	se := structEncoder{fields: cachedTypeFields(t).list}
+	se.fieldEncs = make([]encoderFunc, len(se.fields))
+	for i, f := range se.fields {
+		se.fieldEncs[i] = typeEncoder(t.Field(f.index).Type)
+	}
+	return se.encode
 }
 
-func (e *encodeState) marshalStruct(val reflect.Value) error {
+func (se structEncoder) encode(e *encodeState, val reflect.Value) error {
 	if err := e.WriteByte('d'); err != nil {
 		return err
 	}
-	valType := val.Type()
-
-	fields := positionedFieldsByName{}
-	for i := 0; i < val.NumField(); i++ {
-		fieldOpt := extractFieldOptions(val, valType.Field(i).Name)
-		if len(fieldOpt) == 0 {
+	for i, f := range se.fields {
+		field := val.Field(f.index)
+		if f.omitEmpty && isEmptyValue(field) {
 			continue
 		}
-		fields = append(fields, positionedField{[]byte(fieldOpt), i})
-	}
-	sort.Sort(fields)
-	for _, f := range fields {
-		if err := e.marshal(reflect.ValueOf(f.name)); err != nil {
+		if err := e.writeString([]byte(f.name)); err != nil {
 			return err
 		}
-		if err := e.marshal(val.Field(f.pos)); err != nil {
+		if f.asString {
+			if err := e.marshalNumberAsString(field); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := se.fieldEncs[i](e, field); err != nil {
 			return err
 		}
 	}
 	return e.WriteByte('e')
 }
 
+// writeString writes b as a bencode string.
+func (e *encodeState) writeString(b []byte) error {
+	if _, err := e.Write(strconv.AppendInt([]byte{}, int64(len(b)), 10)); err != nil {
+		return err
+	}
+	if err := e.WriteByte(':'); err != nil {
+		return err
+	}
+	_, err := e.Write(b)
+	return err
+}
+
+func (e *encodeState) marshalNumberAsString(val reflect.Value) error {
+	for val.Kind() == reflect.Ptr && !val.IsNil() {
+		val = val.Elem()
+	}
+	var number []byte
+	switch val.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		number = strconv.AppendInt([]byte{}, val.Int(), 10)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		number = strconv.AppendUint([]byte{}, val.Uint(), 10)
+	default:
+		return errors.New("Option 'string' used on field of kind: " + val.Kind().String())
+	}
+	return e.marshal(reflect.ValueOf(number))
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
 func (e *encodeState) marshalPtr(val reflect.Value) error {
 	return e.marshal(val.Elem())
 }
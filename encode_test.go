@@ -3,6 +3,8 @@ package bencoding
 import (
 	"bytes"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -319,3 +321,184 @@ func TestMarshalingTaggedStructShouldOrderFieldsBasedOnTaggedNames(t *testing.T)
 		t.Fatalf("Expected '%s', got '%s'", expected, string(s))
 	}
 }
+
+type upperString string
+
+func (s upperString) MarshalBencode() ([]byte, error) {
+	return Marshal(strings.ToUpper(string(s)))
+}
+
+type badMarshaler struct{}
+
+func (badMarshaler) MarshalBencode() ([]byte, error) {
+	return []byte("i1"), nil
+}
+
+func TestMarshalerIsUsed(t *testing.T) {
+	type T struct {
+		Name upperString `bencoding:"name"`
+		List []upperString
+	}
+	test := T{"foo", []upperString{"a", "b"}}
+	expected := "d4:Listl1:A1:Be4:name3:FOOe"
+	if s, e := Marshal(test); e != nil {
+		t.Fatal(e)
+	} else if string(s) != expected {
+		t.Fatalf("Expected '%s', got '%s'", expected, string(s))
+	}
+}
+
+func TestMarshalerReturningInvalidBencodeFails(t *testing.T) {
+	if s, e := Marshal([]interface{}{badMarshaler{}}); e == nil {
+		t.Fatalf("Expected error, got '%s'", string(s))
+	}
+}
+
+func TestRawMessageMarshaling(t *testing.T) {
+	test := map[string]interface{}{
+		"b": RawMessage("d1:zi1e1:ai2ee"),
+		"a": &RawMessage{'i', '1', 'e'},
+	}
+	expected := "d1:ai1e1:bd1:zi1e1:ai2eee"
+	if s, e := Marshal(test); e != nil {
+		t.Fatal(e)
+	} else if string(s) != expected {
+		t.Fatalf("Expected '%s', got '%s'", expected, string(s))
+	}
+	if s, e := Marshal(RawMessage(nil)); e == nil {
+		t.Fatalf("Expected empty RawMessage to fail, got '%s'", string(s))
+	}
+}
+
+func TestParsingTagOptions(t *testing.T) {
+	type T struct {
+		Field1 int `bencoding:"foo,omitempty"`
+		Field2 int `bencoding:",omitempty,string"`
+		Field3 int `bencoding:"publisher.location"`
+	}
+	var testVar T
+	test := reflect.ValueOf(testVar)
+	if key, opts := extractFieldKeyAndOptions(test, "Field1"); key != "foo" || !opts.Contains("omitempty") || opts.Contains("string") {
+		t.Fatalf("Expected 'foo' with omitempty, got '%v' with '%v'", key, opts)
+	}
+	if key, opts := extractFieldKeyAndOptions(test, "Field2"); key != "Field2" || !opts.Contains("omitempty") || !opts.Contains("string") {
+		t.Fatalf("Expected 'Field2' with omitempty and string, got '%v' with '%v'", key, opts)
+	}
+	if key, opts := extractFieldKeyAndOptions(test, "Field3"); key != "publisher.location" || opts != "" {
+		t.Fatalf("Expected 'publisher.location' without options, got '%v' with '%v'", key, opts)
+	}
+}
+
+func TestMarshalingWithOmitempty(t *testing.T) {
+	type T struct {
+		Comment      string         `bencoding:"comment,omitempty"`
+		CreationDate int64          `bencoding:"creation date,omitempty"`
+		List         []string       `bencoding:"list,omitempty"`
+		Ptr          *int           `bencoding:"ptr,omitempty"`
+		Any          interface{}    `bencoding:"any,omitempty"`
+		Name         string         `bencoding:"name"`
+		Dict         map[string]int `bencoding:",omitempty"`
+	}
+	expected := "d4:name0:e"
+	if s, e := Marshal(T{}); e != nil {
+		t.Fatal(e)
+	} else if string(s) != expected {
+		t.Fatalf("Expected '%s', got '%s'", expected, string(s))
+	}
+	i := 0
+	expected = "d4:Dictd1:ai1ee3:anyi2e7:comment1:c13:creation datei3e4:listl1:xe4:name1:n3:ptri0ee"
+	if s, e := Marshal(T{"c", 3, []string{"x"}, &i, 2, "n", map[string]int{"a": 1}}); e != nil {
+		t.Fatal(e)
+	} else if string(s) != expected {
+		t.Fatalf("Expected '%s', got '%s'", expected, string(s))
+	}
+}
+
+func TestMarshalingWithStringOption(t *testing.T) {
+	type T struct {
+		Port  uint16 `bencoding:"port,string"`
+		Delta int    `bencoding:"delta,string"`
+	}
+	expected := "d5:delta2:-34:port4:6881e"
+	if s, e := Marshal(T{6881, -3}); e != nil {
+		t.Fatal(e)
+	} else if string(s) != expected {
+		t.Fatalf("Expected '%s', got '%s'", expected, string(s))
+	}
+	type Bad struct {
+		Name string `bencoding:"name,string"`
+	}
+	if s, e := Marshal(Bad{"x"}); e == nil {
+		t.Fatalf("Expected error for string option on string field, got '%s'", string(s))
+	}
+}
+
+type node struct {
+	Name     string  `bencoding:"name"`
+	Children []*node `bencoding:"children,omitempty"`
+}
+
+func TestMarshalingRecursiveType(t *testing.T) {
+	tree := node{"root", []*node{{"a", nil}, {"b", []*node{{"c", nil}}}}}
+	expected := "d8:childrenld4:name1:aed8:childrenld4:name1:cee4:name1:bee4:name4:roote"
+	if s, e := Marshal(tree); e != nil {
+		t.Fatal(e)
+	} else if string(s) != expected {
+		t.Fatalf("Expected '%s', got '%s'", expected, string(s))
+	}
+	var decoded node
+	if e := Unmarshal([]byte(expected), &decoded); e != nil {
+		t.Fatal(e)
+	} else if !reflect.DeepEqual(tree, decoded) {
+		t.Fatalf("Expected '%v', got '%v'", tree, decoded)
+	}
+}
+
+func TestConcurrentMarshaling(t *testing.T) {
+	type T struct {
+		A int      `bencoding:"a"`
+		B []string `bencoding:"b"`
+	}
+	expected := "d1:ai1e1:bl1:xee"
+	var wg sync.WaitGroup
+	errs := make(chan string, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v T
+			if e := Unmarshal([]byte(expected), &v); e != nil {
+				errs <- e.Error()
+			} else if s, e := Marshal(v); e != nil {
+				errs <- e.Error()
+			} else if string(s) != expected {
+				errs <- string(s)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Fatalf("Unexpected result: %s", e)
+	}
+}
+
+func BenchmarkMarshalStruct(b *testing.B) {
+	type Peer struct {
+		IP     string `bencoding:"ip"`
+		PeerID []byte `bencoding:"peer id,omitempty"`
+		Port   int    `bencoding:"port"`
+	}
+	type Response struct {
+		Complete   int    `bencoding:"complete"`
+		Incomplete int    `bencoding:"incomplete"`
+		Interval   int    `bencoding:"interval"`
+		Peers      []Peer `bencoding:"peers"`
+	}
+	r := Response{10, 2, 1800, []Peer{{"10.0.0.1", nil, 6881}, {"10.0.0.2", []byte("-XX0001-123456789012"), 6882}}}
+	for i := 0; i < b.N; i++ {
+		if _, e := Marshal(r); e != nil {
+			b.Fatal(e)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package bencoding
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// A SyntaxError is a description of a bencode syntax error.
+type SyntaxError struct {
+	Offset int64  // error occurred after reading Offset bytes
+	Msg    string // description of error
+	Field  string // path of the value being decoded, for example "info.files[3].length"
+}
+
+func (e *SyntaxError) Error() string {
+	msg := e.Msg
+	if e.Field != "" {
+		msg += " in '" + e.Field + "'"
+	}
+	return msg + " at offset " + strconv.FormatInt(e.Offset, 10)
+}
+
+// An UnmarshalTypeError describes a bencode value that was
+// not appropriate for a value of a specific Go type.
+type UnmarshalTypeError struct {
+	Value  string       // description of bencode value - "integer", "string", "list", "dictionary"
+	Type   reflect.Type // type of Go value it could not be assigned to
+	Offset int64        // error occurred after reading Offset bytes
+	Field  string       // path of the Go value, for example "info.files[3].length"
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	msg := "cannot unmarshal " + e.Value + " into Go value of type " + e.Type.String()
+	if e.Field != "" {
+		msg += " in '" + e.Field + "'"
+	}
+	return msg + " at offset " + strconv.FormatInt(e.Offset, 10)
+}
+
+// An UnknownFieldError is returned when a decoder set to
+// DisallowUnknownFields finds a dictionary key with no
+// matching struct field.
+type UnknownFieldError struct {
+	Field  string // path of the key, for example "info.foo"
+	Offset int64  // offset of the key
+}
+
+func (e *UnknownFieldError) Error() string {
+	return "unknown field '" + e.Field + "' at offset " + strconv.FormatInt(e.Offset, 10)
+}
+
+// A MissingFieldError is returned when a dictionary lacks a
+// required struct field, or when the key path hashed by a
+// TorrentDecoder is missing.
+type MissingFieldError struct {
+	Field  string // path of the missing key, for example "info.name"
+	Offset int64  // error occurred after reading Offset bytes
+}
+
+func (e *MissingFieldError) Error() string {
+	return "missing field '" + e.Field + "' at offset " + strconv.FormatInt(e.Offset, 10)
+}
+
+// An InvalidUnmarshalError describes an invalid argument passed to Unmarshal.
+// (The argument to Unmarshal must be a non-nil pointer.)
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Ptr {
+		return "Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// A StringLengthError is returned when a string in the input is
+// longer than the limit set with SetMaxStringLength.
+type StringLengthError struct {
+	Length int64 // length announced by the input
+	Limit  int64
+	Offset int64 // error occurred after reading Offset bytes
+}
+
+func (e *StringLengthError) Error() string {
+	return "string of length " + strconv.FormatInt(e.Length, 10) + " exceeds limit of " +
+		strconv.FormatInt(e.Limit, 10) + " at offset " + strconv.FormatInt(e.Offset, 10)
+}
+
+// A DepthError is returned when lists and dictionaries in the input
+// are nested deeper than the limit set with SetMaxDepth.
+type DepthError struct {
+	Limit  int
+	Offset int64 // error occurred after reading Offset bytes
+}
+
+func (e *DepthError) Error() string {
+	return "nesting exceeds depth limit of " + strconv.Itoa(e.Limit) +
+		" at offset " + strconv.FormatInt(e.Offset, 10)
+}
+
+// An InputSizeError is returned when decoding would read more input
+// than the limit set with SetMaxBytes.
+type InputSizeError struct {
+	Limit int64
+}
+
+func (e *InputSizeError) Error() string {
+	return "input exceeds limit of " + strconv.FormatInt(e.Limit, 10) + " bytes"
+}
+
+// An ElementCountError is returned when the input holds more list
+// elements and dictionary entries than the limit set with
+// SetMaxElements.
+type ElementCountError struct {
+	Limit  int64
+	Offset int64 // error occurred after reading Offset bytes
+}
+
+func (e *ElementCountError) Error() string {
+	return "input exceeds limit of " + strconv.FormatInt(e.Limit, 10) +
+		" list elements and dictionary entries at offset " + strconv.FormatInt(e.Offset, 10)
+}
@@ -2,28 +2,14 @@ package main
 
 // This program will read it's first argument,
 // parse it as a bencoded torrent file and
-// unmarshal it to stdout.
+// print a summary of it to stdout.
 
 import (
 	"fmt"
-	"github.com/tumdum/bencoding"
+	"github.com/tumdum/bencoding/metainfo"
 	"os"
 )
 
-type Info struct {
-	Length      int64  `bencoding:"length"`
-	Name        string `bencoding:"name"`
-	PieceLength int64  `bencoding:"piece length"`
-}
-
-type Torrent struct {
-	Announce     string        `bencoding:"announce"`
-	AnnounceList []interface{} `bencoding:"announce-list"`
-	Comment      string        `bencoding:"comment"`
-	CreationDate int64         `bencoding:"creation date"`
-	Info         Info          `bencoding:"info"`
-}
-
 func usage() {
 	fmt.Printf("usage: $%s file.torrent\n", os.Args[0])
 }
@@ -38,11 +24,10 @@ func main() {
 		panic(e)
 	}
 	defer f.Close()
-	d := bencoding.NewTorrentDecoder(f)
-	var t Torrent
-	if h, e := d.Decode(&t); e != nil {
+	if m, e := metainfo.Load(f); e != nil {
 		panic(e)
 	} else {
-		fmt.Printf("  torrent: %+v\n     hash: %v\n", t, h)
+		fmt.Printf("announce: %v\n    name: %v\n  length: %v\n  pieces: %v\n    hash: %v\n",
+			m.Announce, m.Info.Name, m.Info.TotalLength(), m.Info.NumPieces(), m.InfoHash)
 	}
 }
@@ -2,44 +2,130 @@ package bencoding
 
 import (
 	"bufio"
-	"crypto/sha1"
-	"hash"
+	"errors"
+	"io"
+	"math"
 )
 
+// errInputLimit is returned by hashingRreader once reading
+// would go past its limit.
+var errInputLimit = errors.New("input limit exceeded")
+
 type hashingRreader struct {
 	b          *bufio.Reader
-	hash       hash.Hash
+	hash       io.Writer
 	shouldHash bool
+	span       Span // input covered by hash
+	offset     int64
+	limit      int64 // maximum offset, 0 means no limit
+}
+
+// allows reports whether n more bytes may be consumed.
+func (hr *hashingRreader) allows(n int64) bool {
+	return hr.limit <= 0 || hr.offset+n <= hr.limit
 }
 
 func (hr *hashingRreader) Peek(n int) ([]byte, error) {
+	if !hr.allows(int64(n)) {
+		return nil, errInputLimit
+	}
 	b, e := hr.b.Peek(n)
 	return b, e
 }
 
+// Buffered returns the bytes read from the underlying
+// reader but not consumed yet.
+func (hr *hashingRreader) Buffered() []byte {
+	b, _ := hr.b.Peek(hr.b.Buffered())
+	return b
+}
+
 func (hr *hashingRreader) ReadByte() (byte, error) {
+	if !hr.allows(1) {
+		return 0, errInputLimit
+	}
 	b, e := hr.b.ReadByte()
+	if e == nil {
+		hr.offset++
+	}
 	if hr.shouldHash && e == nil {
 		hr.hash.Write([]byte{b})
 	}
 	return b, e
 }
 
+func (hr *hashingRreader) Read(p []byte) (int, error) {
+	if hr.limit > 0 && int64(len(p)) > hr.limit-hr.offset {
+		if hr.offset >= hr.limit {
+			return 0, errInputLimit
+		}
+		p = p[:hr.limit-hr.offset]
+	}
+	n, e := hr.b.Read(p)
+	hr.offset += int64(n)
+	if hr.shouldHash {
+		hr.hash.Write(p[:n])
+	}
+	return n, e
+}
+
+// Discard skips the next n bytes, hashing them if needed.
+func (hr *hashingRreader) Discard(n int64) error {
+	if !hr.allows(n) {
+		return errInputLimit
+	}
+	if hr.shouldHash {
+		written, e := io.CopyN(hr.hash, hr.b, n)
+		hr.offset += written
+		return e
+	}
+	for n > 0 {
+		chunk := n
+		if chunk > math.MaxInt32 {
+			chunk = math.MaxInt32
+		}
+		discarded, e := hr.b.Discard(int(chunk))
+		hr.offset += int64(discarded)
+		n -= int64(discarded)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// ReadBytes reads until the first occurrence of delim, which must
+// be found within the reader's buffer; this bounds the length of
+// integers and string lengths in hostile input.
 func (hr *hashingRreader) ReadBytes(delim byte) ([]byte, error) {
-	b, e := hr.b.ReadBytes(delim)
+	s, e := hr.b.ReadSlice(delim)
+	b := append([]byte(nil), s...)
+	hr.offset += int64(len(b))
 	if hr.shouldHash && e == nil {
 		hr.hash.Write(b)
 	}
+	if e == nil && hr.limit > 0 && hr.offset > hr.limit {
+		return b, errInputLimit
+	}
 	return b, e
 }
 
-func (hr *hashingRreader) StartHasing() {
-	if !hr.shouldHash {
-		hr.hash = sha1.New()
-		hr.shouldHash = true
-	}
+// StartHashing feeds everything read from now on into w.
+func (hr *hashingRreader) StartHashing(w io.Writer) {
+	hr.hash = w
+	hr.shouldHash = true
+	hr.span = Span{Start: hr.offset}
 }
 
+// StopHashing ends the span started by StartHashing.
 func (hr *hashingRreader) StopHashing() {
 	hr.shouldHash = false
+	hr.span.End = hr.offset
+}
+
+// ResetHash forgets any previously computed hash.
+func (hr *hashingRreader) ResetHash() {
+	hr.hash = nil
+	hr.shouldHash = false
+	hr.span = Span{}
 }
@@ -0,0 +1,148 @@
+package bencoding
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// base32Encoding is the unpadded form of base32 used in magnet links.
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// InfoHash is the raw hash of a torrent's info dictionary, as returned
+// by TorrentDecoder.Decode. Its length depends on the hash function
+// used. InfoHash values are comparable and may be used as map keys.
+type InfoHash string
+
+// ParseInfoHash parses a 20 or 32 byte hash written in hexadecimal
+// or in base32, as found in magnet links. Both are case insensitive.
+func ParseInfoHash(s string) (InfoHash, error) {
+	var b []byte
+	var e error
+	switch len(s) {
+	case 40, 64:
+		b, e = hex.DecodeString(s)
+	case 32, 52:
+		b, e = base32Encoding.DecodeString(strings.ToUpper(s))
+	default:
+		e = errors.New("bad length")
+	}
+	if e != nil {
+		return "", errors.New("invalid info hash '" + s + "'")
+	}
+	return InfoHash(b), nil
+}
+
+// String returns the hash escaped for use in tracker announce URLs.
+func (h InfoHash) String() string {
+	return url.QueryEscape(string(h))
+}
+
+// Hex returns the hash in lower case hexadecimal.
+func (h InfoHash) Hex() string {
+	return hex.EncodeToString([]byte(h))
+}
+
+// Base32 returns the hash in unpadded base32.
+func (h InfoHash) Base32() string {
+	return base32Encoding.EncodeToString([]byte(h))
+}
+
+// Bytes returns the raw hash.
+func (h InfoHash) Bytes() []byte {
+	return []byte(h)
+}
+
+// Equal reports whether h and o are the same hash.
+func (h InfoHash) Equal(o InfoHash) bool {
+	return h == o
+}
+
+// V1 returns h as a v1 info hash, if it is 20 bytes long.
+func (h InfoHash) V1() (InfoHashV1, bool) {
+	var v1 InfoHashV1
+	if len(h) != len(v1) {
+		return v1, false
+	}
+	copy(v1[:], h)
+	return v1, true
+}
+
+// V2 returns h as a v2 info hash, if it is 32 bytes long.
+func (h InfoHash) V2() (InfoHashV2, bool) {
+	var v2 InfoHashV2
+	if len(h) != len(v2) {
+		return v2, false
+	}
+	copy(v2[:], h)
+	return v2, true
+}
+
+// MarshalText encodes the hash in hexadecimal.
+func (h InfoHash) MarshalText() ([]byte, error) {
+	return []byte(h.Hex()), nil
+}
+
+// UnmarshalText accepts any form ParseInfoHash does.
+func (h *InfoHash) UnmarshalText(text []byte) error {
+	parsed, e := ParseInfoHash(string(text))
+	if e != nil {
+		return e
+	}
+	*h = parsed
+	return nil
+}
+
+// InfoHashV1 is the SHA-1 info hash of a v1 or hybrid torrent.
+type InfoHashV1 [20]byte
+
+// Hex returns the hash in lower case hexadecimal.
+func (h InfoHashV1) Hex() string {
+	return hex.EncodeToString(h[:])
+}
+
+// Base32 returns the hash in unpadded base32.
+func (h InfoHashV1) Base32() string {
+	return base32Encoding.EncodeToString(h[:])
+}
+
+func (h InfoHashV1) String() string {
+	return h.Hex()
+}
+
+// InfoHash returns the hash as an InfoHash.
+func (h InfoHashV1) InfoHash() InfoHash {
+	return InfoHash(h[:])
+}
+
+// InfoHashV2 is the SHA-256 info hash of a v2 or hybrid torrent.
+type InfoHashV2 [32]byte
+
+// Hex returns the hash in lower case hexadecimal.
+func (h InfoHashV2) Hex() string {
+	return hex.EncodeToString(h[:])
+}
+
+// Base32 returns the hash in unpadded base32.
+func (h InfoHashV2) Base32() string {
+	return base32Encoding.EncodeToString(h[:])
+}
+
+func (h InfoHashV2) String() string {
+	return h.Hex()
+}
+
+// InfoHash returns the hash as an InfoHash.
+func (h InfoHashV2) InfoHash() InfoHash {
+	return InfoHash(h[:])
+}
+
+// Truncate returns the first 20 bytes of the hash, which is what
+// trackers, DHT and the peer wire protocol use to identify a v2 torrent.
+func (h InfoHashV2) Truncate() InfoHashV1 {
+	var t InfoHashV1
+	copy(t[:], h[:])
+	return t
+}
@@ -0,0 +1,86 @@
+package bencoding
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInfoHashFormatting(t *testing.T) {
+	var v2 InfoHashV2
+	for i := range v2 {
+		v2[i] = byte(i)
+	}
+	if s := v2.Hex(); s != "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f" {
+		t.Fatalf("Unexpected hex '%s'", s)
+	}
+	if s := v2.Base32(); s != "AAAQEAYEAUDAOCAJBIFQYDIOB4IBCEQTCQKRMFYYDENBWHA5DYPQ" {
+		t.Fatalf("Unexpected base32 '%s'", s)
+	}
+	v1 := v2.Truncate()
+	if s := v1.Hex(); s != "000102030405060708090a0b0c0d0e0f10111213" {
+		t.Fatalf("Unexpected truncated hash '%s'", s)
+	}
+	if s := v1.Base32(); s != "AAAQEAYEAUDAOCAJBIFQYDIOB4IBCEQT" {
+		t.Fatalf("Unexpected base32 '%s'", s)
+	}
+	if v1.String() != v1.Hex() || v2.String() != v2.Hex() {
+		t.Fatalf("Expected String to return hex")
+	}
+}
+
+func TestParseInfoHash(t *testing.T) {
+	var v1 InfoHashV1
+	for i := range v1 {
+		v1[i] = byte(i)
+	}
+	for _, s := range []string{v1.Hex(), strings.ToUpper(v1.Hex()), v1.Base32(), strings.ToLower(v1.Base32())} {
+		h, e := ParseInfoHash(s)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !h.Equal(v1.InfoHash()) {
+			t.Fatalf("Expected '%s' to parse to %s, got %s", s, v1.Hex(), h.Hex())
+		}
+		if got, ok := h.V1(); !ok || got != v1 {
+			t.Fatalf("Expected v1 hash %v, got %v", v1, got)
+		}
+		if _, ok := h.V2(); ok {
+			t.Fatalf("Expected 20 byte hash not to be a v2 hash")
+		}
+	}
+
+	var v2 InfoHashV2
+	for _, s := range []string{v2.Hex(), v2.Base32()} {
+		if h, e := ParseInfoHash(s); e != nil || h != v2.InfoHash() {
+			t.Fatalf("Expected '%s' to parse to %s, got %s (%v)", s, v2.Hex(), h.Hex(), e)
+		}
+	}
+
+	for _, s := range []string{"", "abc", strings.Repeat("z", 40), strings.Repeat("1", 32)} {
+		if _, e := ParseInfoHash(s); e == nil {
+			t.Fatalf("Expected error for '%s'", s)
+		}
+	}
+}
+
+func TestInfoHashIsTextAndMapKey(t *testing.T) {
+	h := InfoHash("\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13\x14")
+	text, e := h.MarshalText()
+	if e != nil || string(text) != "0102030405060708090a0b0c0d0e0f1011121314" {
+		t.Fatalf("Unexpected text '%s' (%v)", text, e)
+	}
+	var parsed InfoHash
+	if e := parsed.UnmarshalText(text); e != nil {
+		t.Fatal(e)
+	}
+	seen := map[InfoHash]bool{h: true}
+	if !seen[parsed] {
+		t.Fatalf("Expected parsed hash to find map entry")
+	}
+	if h.String() != "%01%02%03%04%05%06%07%08%09%0A%0B%0C%0D%0E%0F%10%11%12%13%14" {
+		t.Fatalf("Unexpected escaped hash '%s'", h.String())
+	}
+	if e := parsed.UnmarshalText([]byte("nope")); e == nil {
+		t.Fatalf("Expected error for invalid text")
+	}
+}
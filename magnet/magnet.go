@@ -0,0 +1,273 @@
+// Package magnet parses and generates magnet links for torrents as
+// described by BEP 9 (http://bittorrent.org/beps/bep_0009.html), with
+// the v2 info hashes of BEP 52 and the select-only parameter of BEP 53
+// (http://bittorrent.org/beps/bep_0053.html).
+package magnet
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tumdum/bencoding"
+	"github.com/tumdum/bencoding/metainfo"
+)
+
+const (
+	btihPrefix = "urn:btih:"
+	btmhPrefix = "urn:btmh:"
+)
+
+// multihashSHA256 starts a multihash holding a 32 byte SHA-256 digest.
+var multihashSHA256 = []byte{0x12, 0x20}
+
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Magnet is a parsed magnet link. At least one of InfoHash and
+// InfoHashV2 is set; a hybrid torrent has both.
+type Magnet struct {
+	InfoHash    bencoding.InfoHash // 20 byte v1 info hash (xt=urn:btih:)
+	InfoHashV2  bencoding.InfoHash // 32 byte v2 info hash (xt=urn:btmh:)
+	DisplayName string             // dn
+	Length      int64              // xl, 0 when unknown
+	Trackers    []string           // tr
+	WebSeeds    []string           // ws
+	Peers       []string           // x.pe, as host:port
+	SelectOnly  []Range            // so, indices of the files to download
+}
+
+// Range is an inclusive range of file indices.
+type Range struct {
+	First, Last int
+}
+
+// Parse parses a magnet link. Parameters other than those in Magnet
+// are ignored, as are exact topics that are not BitTorrent info hashes.
+// Numbered parameters such as xt.1 and tr.2 are accepted.
+func Parse(s string) (*Magnet, error) {
+	u, e := url.Parse(s)
+	if e != nil {
+		return nil, e
+	}
+	if !strings.EqualFold(u.Scheme, "magnet") {
+		return nil, errors.New("not a magnet link: '" + s + "'")
+	}
+	m := &Magnet{}
+	// Parameters are read in order, so that trackers keep the
+	// priority given to them by the link.
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		key, value := param, ""
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			key, value = param[:i], param[i+1:]
+		}
+		if unescaped, e := url.QueryUnescape(value); e != nil {
+			return nil, errors.New("invalid magnet link parameter '" + param + "'")
+		} else if e := m.set(parameter(key), unescaped); e != nil {
+			return nil, e
+		}
+	}
+	if m.InfoHash == "" && m.InfoHashV2 == "" {
+		return nil, errors.New("magnet link has no BitTorrent info hash")
+	}
+	return m, nil
+}
+
+// parameter strips the number from numbered parameters like tr.1.
+func parameter(key string) string {
+	if i := strings.LastIndexByte(key, '.'); i >= 0 {
+		if _, e := strconv.Atoi(key[i+1:]); e == nil {
+			return key[:i]
+		}
+	}
+	return key
+}
+
+func (m *Magnet) set(key, value string) error {
+	switch key {
+	case "xt":
+		return m.setExactTopic(value)
+	case "dn":
+		m.DisplayName = value
+	case "xl":
+		if n, e := strconv.ParseInt(value, 10, 64); e != nil || n < 0 {
+			return errors.New("invalid exact length '" + value + "'")
+		} else {
+			m.Length = n
+		}
+	case "tr":
+		m.Trackers = append(m.Trackers, value)
+	case "ws":
+		m.WebSeeds = append(m.WebSeeds, value)
+	case "x.pe":
+		m.Peers = append(m.Peers, value)
+	case "so":
+		if ranges, e := parseSelectOnly(value); e != nil {
+			return e
+		} else {
+			m.SelectOnly = append(m.SelectOnly, ranges...)
+		}
+	}
+	return nil
+}
+
+func (m *Magnet) setExactTopic(value string) error {
+	var h bencoding.InfoHash
+	var target *bencoding.InfoHash
+	switch {
+	case hasPrefixFold(value, btihPrefix):
+		parsed, e := bencoding.ParseInfoHash(value[len(btihPrefix):])
+		if e != nil || len(parsed) != 20 {
+			return errors.New("invalid btih info hash '" + value + "'")
+		}
+		h, target = parsed, &m.InfoHash
+	case hasPrefixFold(value, btmhPrefix):
+		parsed, e := parseMultihash(value[len(btmhPrefix):])
+		if e != nil {
+			return e
+		}
+		h, target = parsed, &m.InfoHashV2
+	default:
+		return nil
+	}
+	if *target != "" && *target != h {
+		return errors.New("conflicting info hashes in magnet link")
+	}
+	*target = h
+	return nil
+}
+
+// parseMultihash decodes a SHA-256 multihash written in hexadecimal
+// or in base32.
+func parseMultihash(s string) (bencoding.InfoHash, error) {
+	b, e := hex.DecodeString(s)
+	if e != nil {
+		b, e = base32Encoding.DecodeString(strings.ToUpper(s))
+	}
+	if e != nil || len(b) != len(multihashSHA256)+32 || !bytes.HasPrefix(b, multihashSHA256) {
+		return "", errors.New("invalid btmh info hash '" + s + "'")
+	}
+	return bencoding.InfoHash(b[len(multihashSHA256):]), nil
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// parseSelectOnly parses a list like "0,2,4-6".
+func parseSelectOnly(s string) ([]Range, error) {
+	var ranges []Range
+	for _, item := range strings.Split(s, ",") {
+		first, last := item, item
+		if i := strings.IndexByte(item, '-'); i >= 0 {
+			first, last = item[:i], item[i+1:]
+		}
+		r := Range{}
+		var e1, e2 error
+		r.First, e1 = strconv.Atoi(first)
+		r.Last, e2 = strconv.Atoi(last)
+		if e1 != nil || e2 != nil || r.First < 0 || r.Last < r.First {
+			return nil, errors.New("invalid select-only range '" + item + "'")
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// Selected reports whether file i is to be downloaded: always when
+// SelectOnly is empty and otherwise when one of its ranges holds i.
+func (m *Magnet) Selected(i int) bool {
+	if len(m.SelectOnly) == 0 {
+		return true
+	}
+	for _, r := range m.SelectOnly {
+		if r.First <= i && i <= r.Last {
+			return true
+		}
+	}
+	return false
+}
+
+// FromMetaInfo returns a magnet link for a torrent, using the info
+// hashes set by metainfo.Load or Builder.Build. Trackers are taken from
+// the announce list, or the announce URL when there is none.
+func FromMetaInfo(m *metainfo.MetaInfo) *Magnet {
+	magnet := &Magnet{
+		DisplayName: m.Info.Name,
+		WebSeeds:    m.URLList,
+	}
+	// The exact length counts the files' data, not BEP 47 pad files.
+	for _, f := range m.Files() {
+		if !f.Padding {
+			magnet.Length += f.Length
+		}
+	}
+	if m.Info.IsV1() {
+		magnet.InfoHash = m.InfoHash.InfoHash()
+	}
+	if m.Info.IsV2() {
+		magnet.InfoHashV2 = m.InfoHashV2.InfoHash()
+	}
+	seen := map[string]bool{}
+	addTracker := func(tracker string) {
+		if tracker != "" && !seen[tracker] {
+			seen[tracker] = true
+			magnet.Trackers = append(magnet.Trackers, tracker)
+		}
+	}
+	for _, tier := range m.AnnounceList {
+		for _, tracker := range tier {
+			addTracker(tracker)
+		}
+	}
+	if len(magnet.Trackers) == 0 {
+		addTracker(m.Announce)
+	}
+	return magnet
+}
+
+// String returns the magnet link, with the v1 info hash in hexadecimal.
+func (m *Magnet) String() string {
+	var params []string
+	add := func(key, value string) {
+		params = append(params, key+"="+url.QueryEscape(value))
+	}
+	if m.InfoHash != "" {
+		params = append(params, "xt="+btihPrefix+m.InfoHash.Hex())
+	}
+	if m.InfoHashV2 != "" {
+		params = append(params, "xt="+btmhPrefix+hex.EncodeToString(multihashSHA256)+m.InfoHashV2.Hex())
+	}
+	if m.DisplayName != "" {
+		add("dn", m.DisplayName)
+	}
+	if m.Length > 0 {
+		add("xl", strconv.FormatInt(m.Length, 10))
+	}
+	for _, tr := range m.Trackers {
+		add("tr", tr)
+	}
+	for _, ws := range m.WebSeeds {
+		add("ws", ws)
+	}
+	for _, pe := range m.Peers {
+		add("x.pe", pe)
+	}
+	if len(m.SelectOnly) > 0 {
+		ranges := make([]string, len(m.SelectOnly))
+		for i, r := range m.SelectOnly {
+			ranges[i] = strconv.Itoa(r.First)
+			if r.Last != r.First {
+				ranges[i] += "-" + strconv.Itoa(r.Last)
+			}
+		}
+		params = append(params, "so="+strings.Join(ranges, ","))
+	}
+	return "magnet:?" + strings.Join(params, "&")
+}
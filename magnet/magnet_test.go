@@ -0,0 +1,158 @@
+package magnet
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tumdum/bencoding"
+	"github.com/tumdum/bencoding/metainfo"
+)
+
+const (
+	v1Hex    = "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	v1Base32 = "YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK"
+	v2Hex    = "d8dd32ac93357c368556af3ac1d95c9d76bd0dff6fa9833ecdac3d53134efabb"
+)
+
+func mustParseInfoHash(t *testing.T, s string) bencoding.InfoHash {
+	h, e := bencoding.ParseInfoHash(s)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return h
+}
+
+func TestParse(t *testing.T) {
+	m, e := Parse("magnet:?xt=urn:btih:" + v1Hex + "&dn=Some+Name%21&xl=1234" +
+		"&tr=http%3A%2F%2Ftracker%2Fannounce&tr.1=udp://other:80&ws=http://seed/x" +
+		"&x.pe=10.0.0.1:6881&x.pe=[::1]:51413&so=0,2,4-6&so=9&unknown=1&xt=urn:sha1:abc")
+	if e != nil {
+		t.Fatal(e)
+	}
+	expected := &Magnet{
+		InfoHash:    mustParseInfoHash(t, v1Hex),
+		DisplayName: "Some Name!",
+		Length:      1234,
+		Trackers:    []string{"http://tracker/announce", "udp://other:80"},
+		WebSeeds:    []string{"http://seed/x"},
+		Peers:       []string{"10.0.0.1:6881", "[::1]:51413"},
+		SelectOnly:  []Range{{0, 0}, {2, 2}, {4, 6}, {9, 9}},
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, m)
+	}
+	for i, selected := range []bool{true, false, true, false, true, true, true, false, false, true} {
+		if m.Selected(i) != selected {
+			t.Fatalf("Expected file %d selected to be %v", i, selected)
+		}
+	}
+}
+
+func TestParseInfoHashForms(t *testing.T) {
+	v1 := mustParseInfoHash(t, v1Hex)
+	v2 := mustParseInfoHash(t, v2Hex)
+	for link, expected := range map[string]*Magnet{
+		"magnet:?xt=urn:btih:" + v1Base32:                                                         {InfoHash: v1},
+		"MAGNET:?xt=URN:BTIH:" + strings.ToLower(v1Base32):                                        {InfoHash: v1},
+		"magnet:?xt=urn:btih:" + strings.ToUpper(v1Hex):                                           {InfoHash: v1},
+		"magnet:?xt=urn:btmh:1220" + v2Hex:                                                        {InfoHashV2: v2},
+		"magnet:?xt=urn:btih:" + v1Hex + "&xt=urn:btmh:1220" + v2Hex:                              {InfoHash: v1, InfoHashV2: v2},
+		"magnet:?xt.1=urn:btih:" + v1Hex + "&xt.2=urn:btih:" + v1Base32:                           {InfoHash: v1},
+		"magnet:?xt=urn:btmh:" + base32Encoding.EncodeToString(append([]byte{0x12, 0x20}, v2...)): {InfoHashV2: v2},
+	} {
+		if m, e := Parse(link); e != nil {
+			t.Fatalf("%s: %v", link, e)
+		} else if !reflect.DeepEqual(m, expected) {
+			t.Fatalf("%s: expected %+v, got %+v", link, expected, m)
+		}
+	}
+}
+
+func TestParseRejectsBadLinks(t *testing.T) {
+	for link, msg := range map[string]string{
+		"http://example.com/?xt=urn:btih:" + v1Hex:                          "not a magnet link",
+		"magnet:?dn=name":                                                   "no BitTorrent info hash",
+		"magnet:?xt=urn:btih:1234":                                          "invalid btih",
+		"magnet:?xt=urn:btih:" + v2Hex:                                      "invalid btih",
+		"magnet:?xt=urn:btmh:" + v2Hex:                                      "invalid btmh",
+		"magnet:?xt=urn:btmh:1320" + v2Hex:                                  "invalid btmh",
+		"magnet:?xt=urn:btih:" + v1Hex + "&xt=urn:btih:" + v1Hex[2:] + "00": "conflicting",
+		"magnet:?xt=urn:btih:" + v1Hex + "&xl=-1":                           "invalid exact length",
+		"magnet:?xt=urn:btih:" + v1Hex + "&so=1,x":                          "invalid select-only",
+		"magnet:?xt=urn:btih:" + v1Hex + "&so=5-2":                          "invalid select-only",
+		"magnet:?xt=urn:btih:" + v1Hex + "&so=":                             "invalid select-only",
+	} {
+		if _, e := Parse(link); e == nil || !strings.Contains(e.Error(), msg) {
+			t.Fatalf("%s: expected error containing '%s', got %v", link, msg, e)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	m := &Magnet{
+		InfoHash:    mustParseInfoHash(t, v1Base32),
+		InfoHashV2:  mustParseInfoHash(t, v2Hex),
+		DisplayName: "a b&c",
+		Length:      10,
+		Trackers:    []string{"http://t/announce?x=1"},
+		WebSeeds:    []string{"http://seed/"},
+		Peers:       []string{"1.2.3.4:5"},
+		SelectOnly:  []Range{{1, 1}, {3, 5}},
+	}
+	expected := "magnet:?xt=urn:btih:" + v1Hex + "&xt=urn:btmh:1220" + v2Hex +
+		"&dn=a+b%26c&xl=10&tr=http%3A%2F%2Ft%2Fannounce%3Fx%3D1&ws=http%3A%2F%2Fseed%2F&x.pe=1.2.3.4%3A5&so=1,3-5"
+	if s := m.String(); s != expected {
+		t.Fatalf("Expected '%s', got '%s'", expected, s)
+	}
+	parsed, e := Parse(m.String())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(parsed, m) {
+		t.Fatalf("Expected %+v, got %+v", m, parsed)
+	}
+}
+
+func TestFromMetaInfo(t *testing.T) {
+	f, e := os.Open("../data/Acoustic_Alchemy_-_This_Way_(Retail_2007)_-_Jazz_.3711858.TPB.torrent")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer f.Close()
+	mi, e := metainfo.Load(f)
+	if e != nil {
+		t.Fatal(e)
+	}
+	m := FromMetaInfo(mi)
+	if m.InfoHash != mi.InfoHash.InfoHash() || m.InfoHashV2 != "" || m.DisplayName != mi.Info.Name ||
+		m.Length != mi.Info.TotalLength() || len(m.Trackers) == 0 || m.Trackers[0] != mi.AnnounceList[0][0] {
+		t.Fatalf("Unexpected magnet %+v", m)
+	}
+	if !strings.HasPrefix(m.String(), "magnet:?xt=urn:btih:"+mi.InfoHash.Hex()+"&dn=") {
+		t.Fatalf("Unexpected magnet link '%s'", m)
+	}
+
+	v2 := &metainfo.MetaInfo{
+		Announce: "http://tracker/announce",
+		Info: metainfo.Info{Name: "f", PieceLength: metainfo.BlockSize, MetaVersion: 2, FileTree: metainfo.FileTree{
+			"f": {File: &metainfo.FileV2{Length: 1}},
+		}},
+		InfoHash:   bencoding.InfoHashV1{1},
+		InfoHashV2: bencoding.InfoHashV2{2},
+	}
+	m = FromMetaInfo(v2)
+	if m.InfoHash != "" || m.InfoHashV2 != v2.InfoHashV2.InfoHash() || !reflect.DeepEqual(m.Trackers, []string{v2.Announce}) {
+		t.Fatalf("Unexpected magnet %+v", m)
+	}
+
+	padded := &metainfo.MetaInfo{Info: metainfo.Info{Name: "d", PieceLength: metainfo.BlockSize, Files: []metainfo.File{
+		{Length: 100, Path: []string{"a"}},
+		{Length: metainfo.BlockSize - 100, Path: []string{".pad", "16284"}, Attr: "p"},
+		{Length: 5, Path: []string{"b"}},
+	}}}
+	if m = FromMetaInfo(padded); m.Length != 105 {
+		t.Fatalf("Expected length 105 without pad files, got %d", m.Length)
+	}
+}
@@ -0,0 +1,469 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tumdum/bencoding"
+)
+
+// Version selects which kind of torrent a Builder creates.
+type Version int
+
+const (
+	V1     Version   = 1 << iota // BEP 3
+	V2                           // BEP 52
+	Hybrid = V1 | V2             // both, with pad files aligning files to pieces
+)
+
+// Builder creates torrents from files on disk. Fields left at their
+// zero value are omitted from the torrent or, where noted, picked
+// automatically.
+type Builder struct {
+	Announce     string // defaults to the first tracker of AnnounceList
+	AnnounceList [][]string
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	Private      bool
+	URLList      []string
+
+	Name        string  // defaults to the base name of the path given to Build
+	PieceLength int64   // picked from the total length when zero
+	Version     Version // defaults to V1
+	Workers     int     // number of hashing goroutines, defaults to GOMAXPROCS
+}
+
+// sourceFile is a file to be included in a torrent.
+type sourceFile struct {
+	osPath string
+	path   []string // relative to the torrent root
+	length int64
+}
+
+// Build walks the file or directory at path and returns a torrent
+// describing it, with its info hashes set. Encode the result with
+// bencoding.Marshal to get a .torrent file.
+func (b *Builder) Build(path string) (*MetaInfo, error) {
+	version := b.Version
+	if version == 0 {
+		version = V1
+	}
+	if version&^Hybrid != 0 {
+		return nil, errors.New("unknown version " + strconv.Itoa(int(version)))
+	}
+	files, isDir, e := collectFiles(path)
+	if e != nil {
+		return nil, e
+	}
+	var total int64
+	for _, f := range files {
+		total += f.length
+	}
+	pieceLength := b.PieceLength
+	if pieceLength == 0 {
+		pieceLength = choosePieceLength(total)
+	}
+	if pieceLength <= 0 || version&V2 != 0 && (pieceLength < BlockSize || !isPowerOfTwo(pieceLength)) {
+		return nil, errors.New("invalid piece length " + strconv.FormatInt(pieceLength, 10))
+	}
+
+	m := &MetaInfo{
+		Announce:     b.Announce,
+		AnnounceList: b.AnnounceList,
+		Comment:      b.Comment,
+		CreatedBy:    b.CreatedBy,
+		URLList:      b.URLList,
+	}
+	if m.Announce == "" && len(b.AnnounceList) > 0 && len(b.AnnounceList[0]) > 0 {
+		m.Announce = b.AnnounceList[0][0]
+	}
+	if !b.CreationDate.IsZero() {
+		m.CreationDate = b.CreationDate.Unix()
+	}
+	m.Info.Name = b.Name
+	if m.Info.Name == "" {
+		m.Info.Name = filepath.Base(filepath.Clean(path))
+	}
+	if !isDir {
+		files[0].path = []string{m.Info.Name}
+	}
+	// Names Load would refuse, such as ones holding a backslash,
+	// are rejected before any hashing is done.
+	if e := validatePathElement(m.Info.Name); e != nil {
+		return nil, errors.New("invalid name: " + e.Error())
+	}
+	for _, f := range files {
+		for _, element := range f.path {
+			if e := validatePathElement(element); e != nil {
+				return nil, errors.New("invalid path of '" + f.osPath + "': " + e.Error())
+			}
+		}
+	}
+	m.Info.PieceLength = pieceLength
+	if b.Private {
+		m.Info.Private = 1
+	}
+
+	workers := b.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	h := newPieceHasher(pieceLength, workers)
+	if version == V1 {
+		e = h.hashV1(files)
+	} else {
+		e = h.hashAligned(files, version&V1 != 0)
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	if version&V1 != 0 {
+		m.Info.Pieces = h.pieces
+		if isDir {
+			m.Info.Files = v1FileList(files, pieceLength, version == Hybrid)
+		} else {
+			m.Info.Length = total
+		}
+	}
+	if version&V2 != 0 {
+		m.Info.MetaVersion = 2
+		m.Info.FileTree = FileTree{}
+		m.PieceLayers = map[string][]byte{}
+		for i, f := range files {
+			file := &FileV2{Length: f.length}
+			if f.length > 0 {
+				root, layer := h.fileRoot(i, f.length)
+				file.PiecesRoot = root
+				if layer != nil {
+					m.PieceLayers[string(root)] = layer
+				}
+			}
+			m.Info.FileTree.add(f.path, file)
+		}
+		if len(m.PieceLayers) == 0 {
+			m.PieceLayers = nil
+		}
+	}
+
+	info, e := bencoding.Marshal(&m.Info)
+	if e != nil {
+		return nil, e
+	}
+	m.InfoHash = sha1.Sum(info)
+	m.InfoHashV2 = sha256.Sum256(info)
+	return m, nil
+}
+
+// choosePieceLength picks a power of two between 16 KiB and 16 MiB
+// giving at most about a thousand pieces.
+func choosePieceLength(total int64) int64 {
+	pieceLength := int64(BlockSize)
+	for pieceLength < 16<<20 && total/pieceLength > 1000 {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// collectFiles lists the regular files at root in the order torrents
+// store them. A single file is named after itself.
+func collectFiles(root string) ([]sourceFile, bool, error) {
+	stat, e := os.Stat(root)
+	if e != nil {
+		return nil, false, e
+	}
+	if !stat.IsDir() {
+		return []sourceFile{{osPath: root, path: []string{stat.Name()}, length: stat.Size()}}, false, nil
+	}
+	var files []sourceFile
+	e = filepath.Walk(root, func(osPath string, fi os.FileInfo, e error) error {
+		if e != nil || !fi.Mode().IsRegular() {
+			return e
+		}
+		rel, e := filepath.Rel(root, osPath)
+		if e != nil {
+			return e
+		}
+		files = append(files, sourceFile{
+			osPath: osPath,
+			path:   strings.Split(filepath.ToSlash(rel), "/"),
+			length: fi.Size(),
+		})
+		return nil
+	})
+	if e != nil {
+		return nil, false, e
+	}
+	if len(files) == 0 {
+		return nil, false, errors.New("no files in '" + root + "'")
+	}
+	return files, true, nil
+}
+
+// v1FileList returns the v1 file list, with pad files after
+// every file but the last that does not end on a piece boundary.
+func v1FileList(files []sourceFile, pieceLength int64, pad bool) []File {
+	var list []File
+	for i, f := range files {
+		list = append(list, File{Length: f.length, Path: f.path})
+		if tail := f.length % pieceLength; pad && tail != 0 && i != len(files)-1 {
+			padLength := pieceLength - tail
+			list = append(list, File{
+				Length: padLength,
+				Path:   []string{".pad", strconv.FormatInt(padLength, 10)},
+				Attr:   "p",
+			})
+		}
+	}
+	return list
+}
+
+// add inserts file into the tree at path.
+func (t FileTree) add(path []string, file *FileV2) {
+	for _, dir := range path[:len(path)-1] {
+		if t[dir] == nil {
+			t[dir] = &FileTreeEntry{Dir: FileTree{}}
+		}
+		t = t[dir].Dir
+	}
+	t[path[len(path)-1]] = &FileTreeEntry{File: file}
+}
+
+// chunk is a piece of data read for hashing. For v1 its SHA-1 hash
+// is stored at pieces[v1Piece], computed over data followed by
+// v1Padding zeros. For v2 the root of its merkle subtree, v2Width
+// bytes wide, is stored as piece v2Piece of file v2File.
+type chunk struct {
+	data      []byte
+	v1Piece   int
+	v1Padding int64
+	v2File    int
+	v2Piece   int
+	v2Width   int64
+}
+
+// pieceHasher reads files a piece at a time and hashes
+// the pieces in parallel.
+type pieceHasher struct {
+	pieceLength int64
+	workers     int
+	buffers     chan []byte // free buffers
+	allocated   int         // number of buffers allocated so far
+
+	pieces []byte                // v1 piece hashes
+	layers [][][sha256.Size]byte // v2 piece hashes by file
+}
+
+// maxBufferMemory bounds the memory taken by the buffers of
+// a pieceHasher, which are allocated as they are needed.
+const maxBufferMemory = 256 << 20
+
+func newPieceHasher(pieceLength int64, workers int) *pieceHasher {
+	numBuffers := int64(2 * workers)
+	if limit := maxBufferMemory / pieceLength; numBuffers > limit {
+		numBuffers = limit
+	}
+	if numBuffers < 1 {
+		numBuffers = 1
+	}
+	return &pieceHasher{
+		pieceLength: pieceLength,
+		workers:     workers,
+		buffers:     make(chan []byte, numBuffers),
+	}
+}
+
+// buffer returns a free buffer, allocating a new one as long as
+// fewer than cap(h.buffers) exist. It is only called by the
+// goroutine reading the files.
+func (h *pieceHasher) buffer() []byte {
+	select {
+	case buf := <-h.buffers:
+		return buf
+	default:
+	}
+	if h.allocated < cap(h.buffers) {
+		h.allocated++
+		return make([]byte, h.pieceLength)
+	}
+	return <-h.buffers
+}
+
+// run starts the workers, feeds them the chunks produced by read
+// and waits until all are hashed.
+func (h *pieceHasher) run(read func(chunks chan<- chunk) error) error {
+	chunks := make(chan chunk)
+	var wg sync.WaitGroup
+	for i := 0; i < h.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				h.hash(c)
+				h.buffers <- c.data[:cap(c.data)]
+			}
+		}()
+	}
+	e := read(chunks)
+	close(chunks)
+	wg.Wait()
+	return e
+}
+
+func (h *pieceHasher) hash(c chunk) {
+	if c.v1Piece >= 0 {
+		sum := sha1.New()
+		sum.Write(c.data)
+		if c.v1Padding > 0 {
+			sum.Write(make([]byte, c.v1Padding))
+		}
+		sum.Sum(h.pieces[c.v1Piece*PieceHashSize : c.v1Piece*PieceHashSize])
+	}
+	if c.v2File >= 0 {
+		h.layers[c.v2File][c.v2Piece] = blockTreeRoot(c.data, c.v2Width)
+	}
+}
+
+// blockTreeRoot returns the root of the merkle tree over the blocks
+// of data, padded with zero hashes to cover width bytes.
+func blockTreeRoot(data []byte, width int64) [sha256.Size]byte {
+	leaves := make([][sha256.Size]byte, 0, (width+BlockSize-1)/BlockSize)
+	for off := 0; off < len(data); off += BlockSize {
+		end := off + BlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		leaves = append(leaves, sha256.Sum256(data[off:end]))
+	}
+	for int64(len(leaves))*BlockSize < width {
+		leaves = append(leaves, [sha256.Size]byte{})
+	}
+	return merkleRoot(leaves, [sha256.Size]byte{})
+}
+
+// hashV1 hashes the files as one stream of pieces crossing
+// file boundaries.
+func (h *pieceHasher) hashV1(files []sourceFile) error {
+	var total int64
+	for _, f := range files {
+		total += f.length
+	}
+	numPieces := int((total + h.pieceLength - 1) / h.pieceLength)
+	h.pieces = make([]byte, numPieces*PieceHashSize)
+	return h.run(func(chunks chan<- chunk) error {
+		buf := h.buffer()[:0]
+		piece := 0
+		for _, f := range files {
+			r, e := os.Open(f.osPath)
+			if e != nil {
+				return e
+			}
+			remaining := f.length
+			for remaining > 0 {
+				n := h.pieceLength - int64(len(buf))
+				if n > remaining {
+					n = remaining
+				}
+				if _, e := io.ReadFull(r, buf[len(buf):int64(len(buf))+n]); e != nil {
+					r.Close()
+					return errors.New("reading '" + f.osPath + "': " + e.Error())
+				}
+				buf = buf[:int64(len(buf))+n]
+				remaining -= n
+				if int64(len(buf)) == h.pieceLength {
+					chunks <- chunk{data: buf, v1Piece: piece, v2File: -1}
+					piece++
+					buf = h.buffer()[:0]
+				}
+			}
+			r.Close()
+		}
+		if len(buf) > 0 {
+			chunks <- chunk{data: buf, v1Piece: piece, v2File: -1}
+		} else {
+			h.buffers <- buf
+		}
+		return nil
+	})
+}
+
+// hashAligned hashes each file on its own, starting every file on
+// a new piece, for v2 and, when withV1 is set, hybrid torrents. The
+// v1 hash of a file's last piece covers the pad file after it.
+func (h *pieceHasher) hashAligned(files []sourceFile, withV1 bool) error {
+	numV1Pieces := 0
+	h.layers = make([][][sha256.Size]byte, len(files))
+	for i, f := range files {
+		n := int((f.length + h.pieceLength - 1) / h.pieceLength)
+		h.layers[i] = make([][sha256.Size]byte, n)
+		numV1Pieces += n
+	}
+	if withV1 {
+		h.pieces = make([]byte, numV1Pieces*PieceHashSize)
+	}
+	return h.run(func(chunks chan<- chunk) error {
+		v1Piece := 0
+		for i, f := range files {
+			if f.length == 0 {
+				continue
+			}
+			r, e := os.Open(f.osPath)
+			if e != nil {
+				return e
+			}
+			for piece := range h.layers[i] {
+				n := f.length - int64(piece)*h.pieceLength
+				if n > h.pieceLength {
+					n = h.pieceLength
+				}
+				buf := h.buffer()[:n]
+				if _, e := io.ReadFull(r, buf); e != nil {
+					r.Close()
+					return errors.New("reading '" + f.osPath + "': " + e.Error())
+				}
+				c := chunk{data: buf, v1Piece: -1, v2File: i, v2Piece: piece, v2Width: h.pieceLength}
+				if f.length <= h.pieceLength {
+					// A file of up to one piece has a tree
+					// only as wide as its blocks need.
+					c.v2Width = n
+				}
+				if withV1 {
+					c.v1Piece = v1Piece
+					if i != len(files)-1 {
+						c.v1Padding = h.pieceLength - n
+					}
+					v1Piece++
+				}
+				chunks <- c
+			}
+			r.Close()
+		}
+		return nil
+	})
+}
+
+// fileRoot returns the pieces root of file i and, for files larger
+// than a piece, its piece layer.
+func (h *pieceHasher) fileRoot(i int, length int64) ([]byte, []byte) {
+	pieces := h.layers[i]
+	if length <= h.pieceLength {
+		root := pieces[0]
+		return root[:], nil
+	}
+	root := merkleRoot(pieces, zeroHash(log2(h.pieceLength/BlockSize)))
+	layer := make([]byte, 0, len(pieces)*sha256.Size)
+	for _, p := range pieces {
+		layer = append(layer, p[:]...)
+	}
+	return root[:], layer
+}
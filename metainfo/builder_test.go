@@ -0,0 +1,217 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tumdum/bencoding"
+)
+
+// writeTestFiles creates files under a new temporary directory
+// and returns the directory.
+func writeTestFiles(t *testing.T, files map[string][]byte) string {
+	dir, e := ioutil.TempDir("", "metainfo")
+	if e != nil {
+		t.Fatal(e)
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if e := os.MkdirAll(filepath.Dir(path), 0755); e != nil {
+			t.Fatal(e)
+		}
+		if e := ioutil.WriteFile(path, data, 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+	return dir
+}
+
+// buildAndLoad builds a torrent and loads it back from its encoding.
+func buildAndLoad(t *testing.T, b *Builder, path string) *MetaInfo {
+	built, e := b.Build(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	data, e := bencoding.Marshal(built)
+	if e != nil {
+		t.Fatal(e)
+	}
+	m, e := Load(bytes.NewReader(data))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if m.InfoHash != built.InfoHash || m.InfoHashV2 != built.InfoHashV2 {
+		t.Fatalf("Expected info hashes %v and %v, got %v and %v", built.InfoHash, built.InfoHashV2, m.InfoHash, m.InfoHashV2)
+	}
+	return m
+}
+
+var builderFiles = map[string][]byte{
+	"a.txt":      testData(100),
+	"sub/b.bin":  testData(3*BlockSize + 5),
+	"sub/c.bin":  testData(2 * BlockSize),
+	"sub/empty":  nil,
+	"zzz/d.data": testData(BlockSize / 2),
+}
+
+func TestBuildV1(t *testing.T) {
+	dir := writeTestFiles(t, builderFiles)
+	defer os.RemoveAll(dir)
+	b := &Builder{
+		AnnounceList: [][]string{{"http://a/announce"}, {"udp://b:80"}},
+		Comment:      "test",
+		CreatedBy:    "builder",
+		CreationDate: time.Unix(1500000000, 0),
+		Private:      true,
+		URLList:      []string{"http://seed/"},
+		PieceLength:  BlockSize,
+		Workers:      3,
+	}
+	m := buildAndLoad(t, b, dir)
+	if m.Announce != "http://a/announce" || m.Comment != "test" || m.CreatedBy != "builder" ||
+		m.CreationDate != 1500000000 || m.Info.Private != 1 || len(m.URLList) != 1 {
+		t.Fatalf("Unexpected metainfo %+v", m)
+	}
+	if m.Info.Name != filepath.Base(dir) || m.Info.IsV2() {
+		t.Fatalf("Unexpected info %+v", m.Info)
+	}
+	var concatenated []byte
+	var paths [][]string
+	for _, f := range m.Info.Files {
+		paths = append(paths, f.Path)
+		concatenated = append(concatenated, builderFiles[joinPath(f.Path)]...)
+	}
+	expected := [][]string{{"a.txt"}, {"sub", "b.bin"}, {"sub", "c.bin"}, {"sub", "empty"}, {"zzz", "d.data"}}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Expected paths %v, got %v", expected, paths)
+	}
+	for i := 0; i < m.Info.NumPieces(); i++ {
+		end := (i + 1) * BlockSize
+		if end > len(concatenated) {
+			end = len(concatenated)
+		}
+		if sum := sha1.Sum(concatenated[i*BlockSize : end]); !bytes.Equal(sum[:], m.Info.PieceHash(i)) {
+			t.Fatalf("Unexpected hash of piece %d", i)
+		}
+	}
+}
+
+func TestBuildV2(t *testing.T) {
+	dir := writeTestFiles(t, builderFiles)
+	defer os.RemoveAll(dir)
+	m := buildAndLoad(t, &Builder{Version: V2, PieceLength: 2 * BlockSize, Workers: 2}, dir)
+	if m.Info.IsV1() || len(m.PieceLayers) != 1 {
+		t.Fatalf("Unexpected metainfo %+v", m)
+	}
+	for _, f := range m.Files() {
+		data := builderFiles[joinPath(f.Path[1:])]
+		if len(data) == 0 {
+			if f.PiecesRoot != nil {
+				t.Fatalf("Expected no pieces root for empty file")
+			}
+			continue
+		}
+		if root, _ := referenceTree(data, m.Info.PieceLength); !bytes.Equal(root, f.PiecesRoot) {
+			t.Fatalf("Unexpected pieces root of '%s'", joinPath(f.Path))
+		}
+	}
+}
+
+func TestBuildHybrid(t *testing.T) {
+	dir := writeTestFiles(t, builderFiles)
+	defer os.RemoveAll(dir)
+	m := buildAndLoad(t, &Builder{Version: Hybrid, PieceLength: BlockSize}, dir)
+	if e := m.Info.CheckHybrid(); e != nil {
+		t.Fatal(e)
+	}
+	if n := len(m.Info.Files); n != 7 {
+		t.Fatalf("Expected 5 files and 2 pad files, got %d files", n)
+	}
+}
+
+func TestBuildSingleFile(t *testing.T) {
+	dir := writeTestFiles(t, map[string][]byte{"file.iso": testData(5 * BlockSize)})
+	defer os.RemoveAll(dir)
+	for _, version := range []Version{V1, V2, Hybrid} {
+		m := buildAndLoad(t, &Builder{Version: version, Name: "renamed"}, filepath.Join(dir, "file.iso"))
+		if files := m.Files(); len(files) != 1 || joinPath(files[0].Path) != "renamed" || files[0].Length != 5*BlockSize {
+			t.Fatalf("Unexpected files %+v", files)
+		}
+		if version == Hybrid {
+			if e := m.Info.CheckHybrid(); e != nil {
+				t.Fatal(e)
+			}
+		}
+	}
+}
+
+func TestBuildRejectsBadInput(t *testing.T) {
+	dir := writeTestFiles(t, nil)
+	defer os.RemoveAll(dir)
+	if _, e := (&Builder{}).Build(dir); e == nil {
+		t.Fatalf("Expected error for empty directory")
+	}
+	if _, e := (&Builder{}).Build(filepath.Join(dir, "missing")); e == nil {
+		t.Fatalf("Expected error for missing file")
+	}
+	if e := ioutil.WriteFile(filepath.Join(dir, "f"), []byte("x"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := (&Builder{Version: V2, PieceLength: 1000}).Build(dir); e == nil {
+		t.Fatalf("Expected error for piece length invalid in v2")
+	}
+	for _, version := range []Version{4, Hybrid | 8} {
+		if _, e := (&Builder{Version: version}).Build(dir); e == nil || !strings.Contains(e.Error(), "unknown version") {
+			t.Fatalf("Expected error for version %d, got %v", version, e)
+		}
+	}
+}
+
+func TestBuildRejectsNamesLoadRefuses(t *testing.T) {
+	dir := writeTestFiles(t, map[string][]byte{"ok": nil})
+	defer os.RemoveAll(dir)
+	if _, e := (&Builder{Name: "a/b"}).Build(dir); e == nil || !strings.Contains(e.Error(), "invalid name") {
+		t.Fatalf("Expected error for name with a separator, got %v", e)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if e := ioutil.WriteFile(filepath.Join(dir, `a\b`), []byte("x"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := (&Builder{}).Build(dir); e == nil || !strings.Contains(e.Error(), "contains a separator") {
+		t.Fatalf("Expected error for file name with a backslash, got %v", e)
+	}
+}
+
+func TestPieceHasherBoundsBuffers(t *testing.T) {
+	h := newPieceHasher(16<<20, 64)
+	if n := cap(h.buffers); n != maxBufferMemory/(16<<20) || h.allocated != 0 {
+		t.Fatalf("Expected %d lazily allocated buffers, got %d with %d allocated", maxBufferMemory/(16<<20), n, h.allocated)
+	}
+	if h := newPieceHasher(1<<40, 4); cap(h.buffers) != 1 {
+		t.Fatalf("Expected a single buffer for huge pieces, got %d", cap(h.buffers))
+	}
+}
+
+func TestChoosePieceLength(t *testing.T) {
+	for total, expected := range map[int64]int64{
+		0:        BlockSize,
+		1 << 20:  BlockSize,
+		1 << 30:  2 << 20,
+		1 << 50:  16 << 20,
+		17 << 20: 32 << 10,
+	} {
+		if got := choosePieceLength(total); got != expected {
+			t.Fatalf("Expected piece length %d for %d bytes, got %d", expected, total, got)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tumdum/bencoding"
+)
+
+// FileTree is a directory of the "file tree" of a v2 torrent
+// (BEP 52), mapping names to the files and directories in it.
+type FileTree map[string]*FileTreeEntry
+
+// FileTreeEntry is a file, when File is set, or a directory.
+// Files are encoded as a dictionary with the empty key mapping
+// to the file's properties.
+type FileTreeEntry struct {
+	File *FileV2
+	Dir  FileTree
+}
+
+// MaxFileTreeDepth is the deepest nesting of directories
+// accepted in a file tree.
+const MaxFileTreeDepth = 256
+
+var (
+	fileTreeType      = reflect.TypeOf(FileTree{})
+	fileTreeEntryType = reflect.TypeOf(FileTreeEntry{})
+	fileV2Type        = reflect.TypeOf(FileV2{})
+)
+
+// FileV2 holds the properties of a file in a v2 file tree.
+type FileV2 struct {
+	Length     int64  `bencoding:"length"`
+	PiecesRoot []byte `bencoding:"pieces root,omitempty"` // absent for empty files
+}
+
+func (e *FileTreeEntry) MarshalBencode() ([]byte, error) {
+	if e.File != nil {
+		return bencoding.Marshal(map[string]*FileV2{"": e.File})
+	}
+	return bencoding.Marshal(e.Dir)
+}
+
+func (e *FileTreeEntry) UnmarshalBencode(data []byte) error {
+	v, err := decodeTreeValue(data)
+	if err != nil {
+		return err
+	}
+	return e.fromValue(v, nil, 1)
+}
+
+// UnmarshalBencode decodes the whole tree in one pass into a
+// bencoding.Value and builds the tree from that, rather than
+// decoding every directory again from its raw bytes.
+func (t *FileTree) UnmarshalBencode(data []byte) error {
+	v, err := decodeTreeValue(data)
+	if err != nil {
+		return err
+	}
+	return t.fromValue(v, nil, 0)
+}
+
+// decodeTreeValue decodes a file tree node, refusing trees
+// nested deeper than MaxFileTreeDepth.
+func decodeTreeValue(data []byte) (*bencoding.Value, error) {
+	d := bencoding.NewBytesDecoder(data)
+	// A file adds two dictionaries below its directory: the
+	// one holding the empty key and the file's properties.
+	d.SetMaxDepth(MaxFileTreeDepth + 2)
+	var v bencoding.Value
+	if err := d.Decode(&v); err != nil {
+		if depth, ok := err.(*bencoding.DepthError); ok {
+			return nil, treeDepthError(depth.Offset, nil)
+		}
+		return nil, err
+	}
+	return &v, nil
+}
+
+// treeTypeError reports a node of the wrong kind at path.
+func treeTypeError(v *bencoding.Value, t reflect.Type, path []string) error {
+	return &bencoding.UnmarshalTypeError{Value: v.Kind.String(), Type: t, Offset: v.Span.Start, Field: strings.Join(path, ".")}
+}
+
+func treeDepthError(offset int64, path []string) error {
+	return &bencoding.SyntaxError{
+		Offset: offset,
+		Msg:    "file tree nested deeper than " + strconv.Itoa(MaxFileTreeDepth) + " levels",
+		Field:  strings.Join(path, "."),
+	}
+}
+
+func (t *FileTree) fromValue(v *bencoding.Value, path []string, depth int) error {
+	if v.Kind != bencoding.Dict {
+		return treeTypeError(v, fileTreeType, path)
+	}
+	if depth > MaxFileTreeDepth {
+		return treeDepthError(v.Span.Start, path)
+	}
+	*t = make(FileTree, len(v.Dict))
+	for i := range v.Dict {
+		entry := &v.Dict[i]
+		child := &FileTreeEntry{}
+		if err := child.fromValue(&entry.Value, append(path[:len(path):len(path)], entry.Key), depth+1); err != nil {
+			return err
+		}
+		(*t)[entry.Key] = child
+	}
+	return nil
+}
+
+func (e *FileTreeEntry) fromValue(v *bencoding.Value, path []string, depth int) error {
+	if v.Kind != bencoding.Dict {
+		return treeTypeError(v, fileTreeEntryType, path)
+	}
+	if file := v.Lookup(""); file != nil {
+		if len(v.Dict) != 1 {
+			return &bencoding.SyntaxError{
+				Offset: v.Span.Start,
+				Msg:    "file tree node is both a file and a directory",
+				Field:  strings.Join(path, "."),
+			}
+		}
+		e.File, e.Dir = &FileV2{}, nil
+		return e.File.fromValue(file, append(path[:len(path):len(path)], ""))
+	}
+	e.File = nil
+	return e.Dir.fromValue(v, path, depth)
+}
+
+func (f *FileV2) fromValue(v *bencoding.Value, path []string) error {
+	if v.Kind != bencoding.Dict {
+		return treeTypeError(v, fileV2Type, path)
+	}
+	for i := range v.Dict {
+		entry := &v.Dict[i]
+		switch entry.Key {
+		case "length":
+			if entry.Value.Kind != bencoding.Int {
+				return treeTypeError(&entry.Value, reflect.TypeOf(f.Length), append(path, entry.Key))
+			}
+			f.Length = entry.Value.Int
+		case "pieces root":
+			if entry.Value.Kind != bencoding.String {
+				return treeTypeError(&entry.Value, reflect.TypeOf(f.PiecesRoot), append(path, entry.Key))
+			}
+			f.PiecesRoot = entry.Value.Bytes
+		}
+	}
+	return nil
+}
+
+// walk calls fn for each file in the tree, in the
+// order of their paths as v2 torrents define it.
+func (t FileTree) walk(path []string, fn func(path []string, f *FileV2) error) error {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		childPath := append(path[:len(path):len(path)], name)
+		if entry := t[name]; entry.File != nil {
+			if e := fn(childPath, entry.File); e != nil {
+				return e
+			}
+		} else if e := entry.Dir.walk(childPath, fn); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// IsV2 reports whether the info dictionary has the v2 fields.
+func (info *Info) IsV2() bool {
+	return info.MetaVersion == 2
+}
+
+// IsV1 reports whether the info dictionary has the v1 fields;
+// hybrid torrents are both v1 and v2.
+func (info *Info) IsV1() bool {
+	return !info.IsV2() || len(info.Pieces) != 0 || info.Length != 0 || len(info.Files) != 0
+}
+
+// singleFileV2 reports whether the file tree holds only a file
+// named after the torrent.
+func (info *Info) singleFileV2() bool {
+	entry, ok := info.FileTree[info.Name]
+	return len(info.FileTree) == 1 && ok && entry.File != nil
+}
+
+// V2Files returns the files of the v2 file tree in order. Paths
+// start with the torrent name as for Files.
+func (info *Info) V2Files() []FileEntry {
+	var prefix []string
+	if !info.singleFileV2() {
+		prefix = []string{info.Name}
+	}
+	var entries []FileEntry
+	var offset int64
+	info.FileTree.walk(prefix, func(path []string, f *FileV2) error {
+		entries = append(entries, FileEntry{Path: path, Length: f.Length, Offset: offset, PiecesRoot: f.PiecesRoot})
+		offset += f.Length
+		return nil
+	})
+	return entries
+}
+
+func (info *Info) validateV2() error {
+	if info.MetaVersion != 2 {
+		return errors.New("unsupported meta version " + strconv.FormatInt(info.MetaVersion, 10))
+	}
+	if e := validatePathElement(info.Name); e != nil {
+		return errors.New("invalid name: " + e.Error())
+	}
+	if info.PieceLength < BlockSize || !isPowerOfTwo(info.PieceLength) {
+		return errors.New("invalid piece length " + strconv.FormatInt(info.PieceLength, 10) + " for v2 torrent")
+	}
+	if len(info.FileTree) == 0 {
+		return errors.New("empty file tree")
+	}
+	return info.FileTree.walk(nil, func(path []string, f *FileV2) error {
+		for _, element := range path {
+			if e := validatePathElement(element); e != nil {
+				return errors.New("invalid path in file tree: " + e.Error())
+			}
+		}
+		if f.Length < 0 {
+			return errors.New("negative length of file '" + joinPath(path) + "'")
+		}
+		if f.Length > 0 && len(f.PiecesRoot) != sha256.Size {
+			return errors.New("invalid pieces root of file '" + joinPath(path) + "'")
+		}
+		return nil
+	})
+}
+
+// VerifyPieceLayers checks that every file larger than a piece has
+// a piece layer whose merkle root is the file's pieces root, and
+// that there are no other piece layers. The rest of the torrent is
+// checked first, as Load does, so that m need not come from Load.
+func (m *MetaInfo) VerifyPieceLayers() error {
+	return m.validate()
+}
+
+// verifyPieceLayers is VerifyPieceLayers for a torrent whose
+// file tree has already been validated.
+func (m *MetaInfo) verifyPieceLayers() error {
+	pad := zeroHash(log2(m.Info.PieceLength / BlockSize))
+	used := make(map[string]bool)
+	e := m.Info.FileTree.walk(nil, func(path []string, f *FileV2) error {
+		if f.Length <= m.Info.PieceLength {
+			return nil
+		}
+		layer, ok := m.PieceLayers[string(f.PiecesRoot)]
+		if !ok {
+			return errors.New("missing piece layer of file '" + joinPath(path) + "'")
+		}
+		used[string(f.PiecesRoot)] = true
+		numPieces := (f.Length + m.Info.PieceLength - 1) / m.Info.PieceLength
+		if int64(len(layer)) != numPieces*sha256.Size {
+			return errors.New("piece layer of file '" + joinPath(path) + "' has wrong length " + strconv.Itoa(len(layer)))
+		}
+		hashes := make([][sha256.Size]byte, numPieces)
+		for i := range hashes {
+			copy(hashes[i][:], layer[i*sha256.Size:])
+		}
+		if root := merkleRoot(hashes, pad); !bytes.Equal(root[:], f.PiecesRoot) {
+			return errors.New("piece layer of file '" + joinPath(path) + "' does not match its pieces root")
+		}
+		return nil
+	})
+	if e != nil {
+		return e
+	}
+	if len(used) != len(m.PieceLayers) {
+		return errors.New("piece layers hold " + strconv.Itoa(len(m.PieceLayers)-len(used)) + " unused entries")
+	}
+	return nil
+}
+
+func joinPath(path []string) string {
+	return strings.Join(path, "/")
+}
@@ -0,0 +1,216 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tumdum/bencoding"
+)
+
+// referenceTree hashes data the long way: every leaf of the padded
+// tree is stored, and each layer is kept so that the piece layer can
+// be read off directly.
+func referenceTree(data []byte, pieceLength int64) (root []byte, pieceLayer []byte) {
+	var layer [][sha256.Size]byte
+	for off := 0; off < len(data); off += BlockSize {
+		end := off + BlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		layer = append(layer, sha256.Sum256(data[off:end]))
+	}
+	for len(layer)&(len(layer)-1) != 0 {
+		layer = append(layer, [sha256.Size]byte{})
+	}
+	width := int64(BlockSize)
+	for len(layer) > 1 {
+		if width == pieceLength {
+			numPieces := (int64(len(data)) + pieceLength - 1) / pieceLength
+			for _, h := range layer[:numPieces] {
+				pieceLayer = append(pieceLayer, h[:]...)
+			}
+		}
+		next := make([][sha256.Size]byte, len(layer)/2)
+		for i := range next {
+			next[i] = sha256.Sum256(append(append([]byte{}, layer[2*i][:]...), layer[2*i+1][:]...))
+		}
+		layer = next
+		width *= 2
+	}
+	return layer[0][:], pieceLayer
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func v2MetaInfo(pieceLength int64, files map[string][]byte) MetaInfo {
+	m := MetaInfo{
+		Info: Info{
+			Name:        "dir",
+			PieceLength: pieceLength,
+			MetaVersion: 2,
+			FileTree:    FileTree{},
+		},
+		PieceLayers: map[string][]byte{},
+	}
+	for name, data := range files {
+		file := &FileV2{Length: int64(len(data))}
+		if len(data) > 0 {
+			root, layer := referenceTree(data, pieceLength)
+			file.PiecesRoot = root
+			if int64(len(data)) > pieceLength {
+				m.PieceLayers[string(root)] = layer
+			}
+		}
+		path := strings.Split(name, "/")
+		tree := m.Info.FileTree
+		for _, dir := range path[:len(path)-1] {
+			if tree[dir] == nil {
+				tree[dir] = &FileTreeEntry{Dir: FileTree{}}
+			}
+			tree = tree[dir].Dir
+		}
+		tree[path[len(path)-1]] = &FileTreeEntry{File: file}
+	}
+	return m
+}
+
+func TestLoadV2Torrent(t *testing.T) {
+	pieceLength := int64(2 * BlockSize)
+	m := v2MetaInfo(pieceLength, map[string][]byte{
+		"b/big":   testData(5*BlockSize + 100),
+		"a.txt":   testData(100),
+		"b/empty": nil,
+		"b/c/two": testData(2 * BlockSize),
+	})
+	data, e := bencoding.Marshal(m)
+	if e != nil {
+		t.Fatal(e)
+	}
+	loaded, e := Load(bytes.NewReader(data))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if loaded.Info.IsV1() || !loaded.Info.IsV2() || len(loaded.PieceLayers) != 1 {
+		t.Fatalf("Unexpected metainfo %+v", loaded)
+	}
+	var paths []string
+	for _, f := range loaded.Files() {
+		paths = append(paths, joinPath(f.Path))
+	}
+	expected := []string{"dir/a.txt", "dir/b/big", "dir/b/c/two", "dir/b/empty"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Expected files %v, got %v", expected, paths)
+	}
+	if total := loaded.Info.TotalLength(); total != 7*BlockSize+200 {
+		t.Fatalf("Unexpected total length %d", total)
+	}
+
+	d := bencoding.NewBytesTorrentDecoder(data)
+	if _, e := d.Decode(&struct{}{}); e != nil {
+		t.Fatal(e)
+	}
+	span := d.HashedSpan()
+	if loaded.InfoHashV2 != sha256.Sum256(data[span.Start:span.End]) {
+		t.Fatalf("Unexpected v2 info hash %v", loaded.InfoHashV2)
+	}
+}
+
+func TestLoadSingleFileV2Torrent(t *testing.T) {
+	m := v2MetaInfo(BlockSize, map[string][]byte{"dir": testData(3 * BlockSize)})
+	data, e := bencoding.Marshal(m)
+	if e != nil {
+		t.Fatal(e)
+	}
+	loaded, e := Load(bytes.NewReader(data))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if files := loaded.Files(); len(files) != 1 || joinPath(files[0].Path) != "dir" {
+		t.Fatalf("Unexpected files %+v", files)
+	}
+}
+
+func TestLoadRejectsBadPieceLayers(t *testing.T) {
+	pieceLength := int64(BlockSize)
+	big := testData(3*BlockSize + 1)
+	root, layer := referenceTree(big, pieceLength)
+	cases := map[string]func(*MetaInfo){
+		"does not match": func(m *MetaInfo) {
+			corrupt := append([]byte{}, layer...)
+			corrupt[0] ^= 1
+			m.PieceLayers[string(root)] = corrupt
+		},
+		"wrong length": func(m *MetaInfo) { m.PieceLayers[string(root)] = layer[sha256.Size:] },
+		"missing":      func(m *MetaInfo) { delete(m.PieceLayers, string(root)) },
+		"unused":       func(m *MetaInfo) { m.PieceLayers[strings.Repeat("x", 32)] = layer },
+		"pieces root":  func(m *MetaInfo) { m.Info.FileTree["big"].File.PiecesRoot = root[1:] },
+		"piece length": func(m *MetaInfo) { m.Info.PieceLength = 3 * BlockSize },
+		"meta version": func(m *MetaInfo) { m.Info.MetaVersion = 3 },
+		"invalid path": func(m *MetaInfo) { m.Info.FileTree[".."] = &FileTreeEntry{File: &FileV2{}} },
+		"invalid name": func(m *MetaInfo) { m.Info.Name = ".." },
+	}
+	for msg, breakMetaInfo := range cases {
+		m := v2MetaInfo(pieceLength, map[string][]byte{"big": big})
+		breakMetaInfo(&m)
+		data, e := bencoding.Marshal(m)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if _, e := Load(bytes.NewReader(data)); e == nil || !strings.Contains(e.Error(), msg) {
+			t.Fatalf("Expected error containing '%s', got %v", msg, e)
+		}
+	}
+}
+
+func TestFileTreeEntryRejectsFileWithChildren(t *testing.T) {
+	var entry FileTreeEntry
+	e := entry.UnmarshalBencode([]byte("d0:d6:lengthi0ee1:xd0:d6:lengthi0eeee"))
+	if e == nil || !strings.Contains(e.Error(), "both a file and a directory") {
+		t.Fatalf("Expected error, got %v", e)
+	}
+}
+
+// nestedTree returns a v2 torrent whose single file
+// is inside depth nested directories.
+func nestedTree(depth int) []byte {
+	file := "d0:d6:lengthi1e11:pieces root32:" + strings.Repeat("r", 32) + "ee"
+	tree := strings.Repeat("d1:a", depth) + "d1:f" + file + strings.Repeat("e", depth+1)
+	return []byte("d4:infod9:file tree" + tree + "12:meta versioni2e4:name1:x12:piece lengthi16384eee")
+}
+
+func TestLoadRejectsDeepFileTree(t *testing.T) {
+	if _, e := Load(bytes.NewReader(nestedTree(MaxFileTreeDepth - 1))); e != nil {
+		t.Fatal(e)
+	}
+	for _, depth := range []int{MaxFileTreeDepth, 9000} {
+		data := nestedTree(depth)
+		_, e := Load(bytes.NewReader(data))
+		se, ok := e.(*bencoding.SyntaxError)
+		if !ok || !strings.Contains(se.Msg, "nested deeper") || se.Field != "info.file tree" || len(se.Error()) > 200 {
+			t.Fatalf("Expected depth error, got %v", e)
+		}
+		// The error is at the dictionary one level too deep.
+		limit := int64(strings.Index(string(data), "d1:a") + 4*MaxFileTreeDepth)
+		if se.Offset < limit || se.Offset > limit+16 {
+			t.Fatalf("Expected offset near %d, got %d", limit, se.Offset)
+		}
+	}
+}
+
+func TestFileTreeErrorLocation(t *testing.T) {
+	data := []byte("d4:infod9:file treed1:ad1:fd0:d6:length1:xeeee12:meta versioni2e4:name1:x12:piece lengthi16384eee")
+	_, e := Load(bytes.NewReader(data))
+	te, ok := e.(*bencoding.UnmarshalTypeError)
+	if !ok || te.Field != "info.file tree.a.f..length" || te.Offset != int64(strings.Index(string(data), "1:xe")) {
+		t.Fatalf("Expected type error of file length, got %v", e)
+	}
+}
@@ -0,0 +1,89 @@
+package metainfo
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A HybridError lists every way in which the v1 and v2 parts
+// of a hybrid torrent disagree.
+type HybridError struct {
+	Mismatches []string
+}
+
+func (e *HybridError) Error() string {
+	return "inconsistent hybrid torrent: " + strings.Join(e.Mismatches, "; ")
+}
+
+// CheckHybrid checks that the v1 file list of a hybrid torrent
+// describes the same files as its v2 file tree: the same names and
+// lengths in the same order, with a pad file (BEP 47) after each
+// file, except the last, that does not end on a piece boundary.
+// Inconsistencies are reported together in a HybridError.
+func (info *Info) CheckHybrid() error {
+	if !info.IsV1() || !info.IsV2() {
+		return errors.New("not a hybrid torrent")
+	}
+	if info.PieceLength <= 0 {
+		return errors.New("invalid piece length " + strconv.FormatInt(info.PieceLength, 10))
+	}
+	var mismatches []string
+	report := func(msg string) {
+		mismatches = append(mismatches, msg)
+	}
+
+	v1 := info.fileEntries()
+	v2 := info.V2Files()
+	i := 0
+	for j, want := range v2 {
+		if i >= len(v1) {
+			report("v1 file list lacks '" + joinPath(want.Path) + "'")
+			continue
+		}
+		got := v1[i]
+		i++
+		if got.Padding {
+			report("unexpected pad file '" + joinPath(got.Path) + "' before '" + joinPath(want.Path) + "'")
+			if i >= len(v1) {
+				report("v1 file list lacks '" + joinPath(want.Path) + "'")
+				continue
+			}
+			got = v1[i]
+			i++
+		}
+		if joinPath(got.Path) != joinPath(want.Path) {
+			report("v1 file '" + joinPath(got.Path) + "' is '" + joinPath(want.Path) + "' in the file tree")
+		}
+		if got.Length != want.Length {
+			report("v1 length " + strconv.FormatInt(got.Length, 10) + " of '" + joinPath(got.Path) +
+				"' differs from its length " + strconv.FormatInt(want.Length, 10) + " in the file tree")
+		}
+
+		tail := got.Length % info.PieceLength
+		last := j == len(v2)-1
+		if tail == 0 || last {
+			if last && i < len(v1) && v1[i].Padding {
+				i++
+			}
+			continue
+		}
+		if i >= len(v1) || !v1[i].Padding {
+			report("missing pad file after '" + joinPath(got.Path) + "'")
+			continue
+		}
+		if pad := v1[i]; pad.Length != info.PieceLength-tail {
+			report("pad file '" + joinPath(pad.Path) + "' has length " + strconv.FormatInt(pad.Length, 10) +
+				", expected " + strconv.FormatInt(info.PieceLength-tail, 10))
+		}
+		i++
+	}
+	for ; i < len(v1); i++ {
+		report("v1 file '" + joinPath(v1[i].Path) + "' is not in the file tree")
+	}
+
+	if mismatches != nil {
+		return &HybridError{Mismatches: mismatches}
+	}
+	return nil
+}
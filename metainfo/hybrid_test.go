@@ -0,0 +1,95 @@
+package metainfo
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/tumdum/bencoding"
+)
+
+func hybridMetaInfo() MetaInfo {
+	pieceLength := int64(BlockSize)
+	m := v2MetaInfo(pieceLength, map[string][]byte{
+		"a":     testData(100),
+		"b/c":   testData(BlockSize),
+		"b/d":   nil,
+		"e.iso": testData(BlockSize + 1),
+	})
+	info := &m.Info
+	info.Files = []File{
+		{Length: 100, Path: []string{"a"}},
+		{Length: BlockSize - 100, Path: []string{".pad", "16284"}, Attr: "p"},
+		{Length: BlockSize, Path: []string{"b", "c"}},
+		{Length: 0, Path: []string{"b", "d"}},
+		{Length: BlockSize + 1, Path: []string{"e.iso"}},
+	}
+	info.Pieces = make([]byte, 4*PieceHashSize)
+	return m
+}
+
+func TestCheckHybridAcceptsConsistentTorrent(t *testing.T) {
+	hybrid := hybridMetaInfo()
+	info := &hybrid.Info
+	if e := info.CheckHybrid(); e != nil {
+		t.Fatal(e)
+	}
+	data, e := bencoding.Marshal(hybrid)
+	if e != nil {
+		t.Fatal(e)
+	}
+	m, e := Load(bytes.NewReader(data))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !m.Info.IsV1() || !m.Info.IsV2() || !m.Files()[1].Padding {
+		t.Fatalf("Expected hybrid torrent with pad file, got %+v", m.Files())
+	}
+
+	info.Files = append(info.Files, File{Length: BlockSize - 1, Path: []string{".pad", "16383"}, Attr: "p"})
+	info.Pieces = make([]byte, 5*PieceHashSize)
+	if e := info.CheckHybrid(); e != nil {
+		t.Fatalf("Expected trailing pad file to be allowed, got %v", e)
+	}
+}
+
+func TestCheckHybridReportsEveryMismatch(t *testing.T) {
+	hybrid := hybridMetaInfo()
+	info := &hybrid.Info
+	info.Files[0].Path = []string{"x"}
+	info.Files[1].Length = 1
+	info.Files[2].Length = BlockSize - 1
+	info.Files[4].Attr = "p"
+	e := info.CheckHybrid()
+	he, ok := e.(*HybridError)
+	if !ok {
+		t.Fatalf("Expected HybridError, got %v", e)
+	}
+	expected := []string{
+		"v1 file 'dir/x' is 'dir/a' in the file tree",
+		"pad file 'dir/.pad/16284' has length 1, expected 16284",
+		"v1 length 16383 of 'dir/b/c' differs from its length 16384 in the file tree",
+		"missing pad file after 'dir/b/c'",
+		"unexpected pad file 'dir/e.iso' before 'dir/e.iso'",
+		"v1 file list lacks 'dir/e.iso'",
+	}
+	if !reflect.DeepEqual(he.Mismatches, expected) {
+		t.Fatalf("Expected mismatches\n%q\ngot\n%q", expected, he.Mismatches)
+	}
+
+	v1 := Info{Name: "x", PieceLength: BlockSize, Length: 1, Pieces: make([]byte, 20)}
+	if e := v1.CheckHybrid(); e == nil {
+		t.Fatalf("Expected error for v1 torrent")
+	}
+}
+
+func TestCheckHybridRejectsInvalidPieceLength(t *testing.T) {
+	for _, pieceLength := range []int64{0, -BlockSize} {
+		hybrid := hybridMetaInfo()
+		hybrid.Info.PieceLength = pieceLength
+		if e := hybrid.Info.CheckHybrid(); e == nil || e.Error() != "invalid piece length "+strconv.FormatInt(pieceLength, 10) {
+			t.Fatalf("Expected piece length error, got %v", e)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package metainfo
+
+import "crypto/sha256"
+
+// BlockSize is the size of the leaf blocks of v2 merkle trees.
+const BlockSize = 16 << 10
+
+// zeroHash returns the root of a tree of 2^height zero leaf hashes,
+// which stands in for the missing nodes past the end of a file.
+func zeroHash(height int) [sha256.Size]byte {
+	var h [sha256.Size]byte
+	for i := 0; i < height; i++ {
+		h = hashPair(h, h)
+	}
+	return h
+}
+
+func hashPair(left, right [sha256.Size]byte) [sha256.Size]byte {
+	var both [2 * sha256.Size]byte
+	copy(both[:], left[:])
+	copy(both[sha256.Size:], right[:])
+	return sha256.Sum256(both[:])
+}
+
+// merkleRoot returns the root of the tree with the given nodes,
+// padded with pad up to the next power of two.
+func merkleRoot(nodes [][sha256.Size]byte, pad [sha256.Size]byte) [sha256.Size]byte {
+	layer := make([][sha256.Size]byte, nextPowerOfTwo(len(nodes)))
+	copy(layer, nodes)
+	for i := len(nodes); i < len(layer); i++ {
+		layer[i] = pad
+	}
+	for len(layer) > 1 {
+		for i := 0; i < len(layer)/2; i++ {
+			layer[i] = hashPair(layer[2*i], layer[2*i+1])
+		}
+		layer = layer[:len(layer)/2]
+	}
+	return layer[0]
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
+
+// log2 returns the base 2 logarithm of the power of two n.
+func log2(n int64) int {
+	height := 0
+	for n > 1 {
+		n /= 2
+		height++
+	}
+	return height
+}
+
+// isPowerOfTwo reports whether n is a positive power of two.
+func isPowerOfTwo(n int64) bool {
+	return n > 0 && n&(n-1) == 0
+}
@@ -0,0 +1,250 @@
+// Package metainfo reads and writes .torrent files as described by
+// BEP 3 (http://bittorrent.org/beps/bep_0003.html) and, for v2 and
+// hybrid torrents, BEP 52 (http://bittorrent.org/beps/bep_0052.html).
+package metainfo
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/tumdum/bencoding"
+)
+
+// PieceHashSize is the length of a SHA-1 piece hash in Info.Pieces.
+const PieceHashSize = 20
+
+// MetaInfo is the content of a .torrent file.
+type MetaInfo struct {
+	Announce     string     `bencoding:"announce,omitempty"`
+	AnnounceList [][]string `bencoding:"announce-list,omitempty"`
+	Comment      string     `bencoding:"comment,omitempty"`
+	CreatedBy    string     `bencoding:"created by,omitempty"`
+	CreationDate int64      `bencoding:"creation date,omitempty"`
+	Encoding     string     `bencoding:"encoding,omitempty"`
+	URLList      URLList    `bencoding:"url-list,omitempty"`
+	Info         Info       `bencoding:"info"`
+
+	// PieceLayers maps the pieces root of each v2 file larger than
+	// a piece to the concatenated hashes of its pieces.
+	PieceLayers map[string][]byte `bencoding:"piece layers,omitempty"`
+
+	// InfoHash and InfoHashV2 are the SHA-1 and SHA-256 hashes of
+	// the info dictionary as found in the input. They are set by
+	// Load and never encoded.
+	InfoHash   bencoding.InfoHashV1 `bencoding:"-"`
+	InfoHashV2 bencoding.InfoHashV2 `bencoding:"-"`
+}
+
+// URLList holds the web seeds of a torrent (BEP 19). It decodes
+// from either a single string or a list of strings.
+type URLList []string
+
+func (l *URLList) UnmarshalBencode(data []byte) error {
+	var url string
+	if e := bencoding.Unmarshal(data, &url); e == nil {
+		*l = nil
+		if url != "" {
+			*l = URLList{url}
+		}
+		return nil
+	}
+	var list []string
+	if e := bencoding.Unmarshal(data, &list); e != nil {
+		return e
+	}
+	*l = list
+	return nil
+}
+
+// Info is the info dictionary of a torrent. Single file v1 torrents
+// set Length, multi file v1 torrents set Files; Name is the file name
+// or the name of the directory holding the files respectively. v2
+// torrents set MetaVersion and FileTree instead, and hybrid torrents
+// set both.
+type Info struct {
+	Name        string   `bencoding:"name"`
+	PieceLength int64    `bencoding:"piece length"`
+	Pieces      []byte   `bencoding:"pieces,omitempty"`
+	Private     int64    `bencoding:"private,omitempty"` // 1 for private torrents (BEP 27)
+	Length      int64    `bencoding:"length,omitempty"`
+	MD5Sum      string   `bencoding:"md5sum,omitempty"`
+	Files       []File   `bencoding:"files,omitempty"`
+	MetaVersion int64    `bencoding:"meta version,omitempty"`
+	FileTree    FileTree `bencoding:"file tree,omitempty"`
+}
+
+// File is a single entry of a multi file torrent.
+type File struct {
+	Length int64    `bencoding:"length"`
+	Path   []string `bencoding:"path"`
+	MD5Sum string   `bencoding:"md5sum,omitempty"`
+	Attr   string   `bencoding:"attr,omitempty"` // file attributes (BEP 47)
+}
+
+// IsPad reports whether the file is a pad file, whose content is
+// zeros that are never stored, aligning the next file to a piece.
+func (f *File) IsPad() bool {
+	return strings.IndexByte(f.Attr, 'p') >= 0
+}
+
+// FileEntry describes where a file lies in the torrent's data.
+type FileEntry struct {
+	Path   []string // starts with the torrent name
+	Length int64
+	Offset int64 // of the first byte in the concatenated data
+
+	PiecesRoot []byte // of v2 files only
+	Padding    bool   // of v1 pad files only
+}
+
+// Load decodes a torrent from r, computing its info hash on the fly,
+// and checks that the result is well formed.
+func Load(r io.Reader) (*MetaInfo, error) {
+	var m MetaInfo
+	d := bencoding.NewTorrentDecoder(r)
+	v1, v2, e := d.DecodeHashes(&m)
+	if e != nil {
+		return nil, e
+	}
+	m.InfoHash, m.InfoHashV2 = v1, v2
+	if e := m.validate(); e != nil {
+		return nil, e
+	}
+	return &m, nil
+}
+
+func (m *MetaInfo) validate() error {
+	if m.Info.IsV1() {
+		if e := m.Info.validateV1(); e != nil {
+			return e
+		}
+	}
+	if m.Info.MetaVersion != 0 || len(m.Info.FileTree) != 0 {
+		if e := m.Info.validateV2(); e != nil {
+			return e
+		}
+		return m.verifyPieceLayers()
+	}
+	return nil
+}
+
+func (info *Info) validateV1() error {
+	if e := validatePathElement(info.Name); e != nil {
+		return errors.New("invalid name: " + e.Error())
+	}
+	if info.PieceLength <= 0 {
+		return errors.New("invalid piece length " + strconv.FormatInt(info.PieceLength, 10))
+	}
+	if len(info.Pieces)%PieceHashSize != 0 {
+		return errors.New("pieces length " + strconv.Itoa(len(info.Pieces)) + " is not a multiple of 20")
+	}
+	if info.Length != 0 && len(info.Files) != 0 {
+		return errors.New("both 'length' and 'files' are set")
+	}
+	if info.Length < 0 {
+		return errors.New("negative length")
+	}
+	for i, f := range info.Files {
+		if f.Length < 0 {
+			return errors.New("negative length of file " + strconv.Itoa(i))
+		}
+		if len(f.Path) == 0 {
+			return errors.New("empty path of file " + strconv.Itoa(i))
+		}
+		for _, element := range f.Path {
+			if e := validatePathElement(element); e != nil {
+				return errors.New("invalid path of file " + strconv.Itoa(i) + ": " + e.Error())
+			}
+		}
+	}
+	if want := (info.TotalLength() + info.PieceLength - 1) / info.PieceLength; int64(info.NumPieces()) != want {
+		return errors.New("got " + strconv.Itoa(info.NumPieces()) + " piece hashes, expected " + strconv.FormatInt(want, 10))
+	}
+	return nil
+}
+
+// validatePathElement rejects names which would
+// escape the directory the torrent is stored in.
+func validatePathElement(s string) error {
+	switch s {
+	case "":
+		return errors.New("empty path element")
+	case ".", "..":
+		return errors.New("path element '" + s + "'")
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '/' || s[i] == '\\' || s[i] == 0 {
+			return errors.New("path element '" + s + "' contains a separator")
+		}
+	}
+	return nil
+}
+
+// IsDir reports whether the torrent holds a directory of files.
+func (info *Info) IsDir() bool {
+	return len(info.Files) != 0
+}
+
+// TotalLength returns the length of all the torrent's data.
+func (info *Info) TotalLength() int64 {
+	if !info.IsV1() {
+		var total int64
+		for _, f := range info.V2Files() {
+			total += f.Length
+		}
+		return total
+	}
+	if !info.IsDir() {
+		return info.Length
+	}
+	var total int64
+	for _, f := range info.Files {
+		total += f.Length
+	}
+	return total
+}
+
+// NumPieces returns the number of piece hashes.
+func (info *Info) NumPieces() int {
+	return len(info.Pieces) / PieceHashSize
+}
+
+// PieceHash returns the SHA-1 hash of piece i.
+func (info *Info) PieceHash(i int) []byte {
+	return info.Pieces[i*PieceHashSize : (i+1)*PieceHashSize]
+}
+
+// PieceSize returns the length of piece i; only
+// the last piece may be shorter than PieceLength.
+func (info *Info) PieceSize(i int) int64 {
+	if i == info.NumPieces()-1 {
+		return info.TotalLength() - int64(i)*info.PieceLength
+	}
+	return info.PieceLength
+}
+
+func (info *Info) fileEntries() []FileEntry {
+	if !info.IsDir() {
+		return []FileEntry{{Path: []string{info.Name}, Length: info.Length}}
+	}
+	entries := make([]FileEntry, 0, len(info.Files))
+	var offset int64
+	for _, f := range info.Files {
+		path := append([]string{info.Name}, f.Path...)
+		entries = append(entries, FileEntry{Path: path, Length: f.Length, Offset: offset, Padding: f.IsPad()})
+		offset += f.Length
+	}
+	return entries
+}
+
+// Files returns the torrent's files in order, single file torrents
+// included. The v1 file list is used when present, the v2 file
+// tree otherwise.
+func (m *MetaInfo) Files() []FileEntry {
+	if !m.Info.IsV1() {
+		return m.Info.V2Files()
+	}
+	return m.Info.fileEntries()
+}
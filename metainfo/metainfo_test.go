@@ -0,0 +1,151 @@
+package metainfo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tumdum/bencoding"
+)
+
+func loadFile(t *testing.T, name string) *MetaInfo {
+	f, e := os.Open(name)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer f.Close()
+	m, e := Load(f)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return m
+}
+
+func TestLoadSingleFileTorrent(t *testing.T) {
+	m := loadFile(t, "../data/debian-7.1.0-amd64-DVD-1.iso.torrent")
+	if m.Announce != "http://bttracker.debian.org:6969/announce" || m.CreationDate != 1371340088 {
+		t.Fatalf("Unexpected metainfo %+v", m)
+	}
+	if m.Info.Name != "debian-7.1.0-amd64-DVD-1.iso" || m.Info.IsDir() {
+		t.Fatalf("Unexpected info name '%s'", m.Info.Name)
+	}
+	if m.Info.TotalLength() != 3998007296 || m.Info.NumPieces() != 3813 {
+		t.Fatalf("Unexpected length %d or number of pieces %d", m.Info.TotalLength(), m.Info.NumPieces())
+	}
+	if !bytes.Equal(m.Info.PieceHash(1), m.Info.Pieces[20:40]) {
+		t.Fatalf("Unexpected hash of piece 1")
+	}
+	if last := m.Info.PieceSize(3812); last != 3998007296-3812*1048576 {
+		t.Fatalf("Unexpected size of last piece %d", last)
+	}
+	files := m.Files()
+	if len(files) != 1 || files[0].Length != 3998007296 || files[0].Path[0] != m.Info.Name {
+		t.Fatalf("Unexpected files %+v", files)
+	}
+	data, e := ioutil.ReadFile("../data/debian-7.1.0-amd64-DVD-1.iso.torrent")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if h, e := bencoding.UnmarshalTorrent(data, &struct{}{}); e != nil || m.InfoHash.InfoHash() != h {
+		t.Fatalf("Expected info hash %s, got %s (%v)", h.Hex(), m.InfoHash.Hex(), e)
+	}
+}
+
+func TestLoadHashMatchesMarshaledInfo(t *testing.T) {
+	m := loadFile(t, "../data/Acoustic_Alchemy_-_This_Way_(Retail_2007)_-_Jazz_.3711858.TPB.torrent")
+	if m.CreatedBy != "uTorrent/1610" || m.Encoding != "UTF-8" || len(m.AnnounceList) != 4 {
+		t.Fatalf("Unexpected metainfo %+v", m)
+	}
+	data, e := bencoding.Marshal(m)
+	if e != nil {
+		t.Fatal(e)
+	}
+	reloaded, e := Load(bytes.NewReader(data))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if reloaded.InfoHash != m.InfoHash {
+		t.Fatalf("Expected info hash %v, got %v", m.InfoHash, reloaded.InfoHash)
+	}
+}
+
+func multiFileInfo() Info {
+	return Info{
+		Name:        "album",
+		PieceLength: 4,
+		Pieces:      make([]byte, 3*PieceHashSize),
+		Files: []File{
+			{Length: 5, Path: []string{"a.txt"}},
+			{Length: 0, Path: []string{"empty"}},
+			{Length: 4, Path: []string{"sub", "b.txt"}, MD5Sum: "d41d8cd98f00b204e9800998ecf8427e"},
+		},
+	}
+}
+
+func TestLoadMultiFileTorrent(t *testing.T) {
+	data, e := bencoding.Marshal(MetaInfo{Announce: "http://tracker", Info: multiFileInfo()})
+	if e != nil {
+		t.Fatal(e)
+	}
+	m, e := Load(bytes.NewReader(data))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !m.Info.IsDir() || m.Info.TotalLength() != 9 || m.Info.PieceSize(2) != 1 {
+		t.Fatalf("Unexpected info %+v", m.Info)
+	}
+	expected := []FileEntry{
+		{Path: []string{"album", "a.txt"}, Length: 5, Offset: 0},
+		{Path: []string{"album", "empty"}, Length: 0, Offset: 5},
+		{Path: []string{"album", "sub", "b.txt"}, Length: 4, Offset: 5},
+	}
+	if files := m.Files(); !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Expected files %+v, got %+v", expected, files)
+	}
+	if m.Info.Files[2].MD5Sum != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Fatalf("Expected md5sum to be decoded")
+	}
+}
+
+func TestLoadRejectsMalformedInfo(t *testing.T) {
+	cases := map[string]func(*Info){
+		"invalid name":         func(i *Info) { i.Name = ".." },
+		"invalid piece":        func(i *Info) { i.PieceLength = 0 },
+		"not a multiple":       func(i *Info) { i.Pieces = i.Pieces[1:] },
+		"both":                 func(i *Info) { i.Length = 9 },
+		"empty path":           func(i *Info) { i.Files[0].Path = nil },
+		"contains a separator": func(i *Info) { i.Files[2].Path = []string{"sub/b.txt"} },
+		"piece hashes":         func(i *Info) { i.Pieces = i.Pieces[PieceHashSize:] },
+	}
+	for msg, breakInfo := range cases {
+		info := multiFileInfo()
+		breakInfo(&info)
+		data, e := bencoding.Marshal(MetaInfo{Info: info})
+		if e != nil {
+			t.Fatal(e)
+		}
+		if _, e := Load(bytes.NewReader(data)); e == nil || !strings.Contains(e.Error(), msg) {
+			t.Fatalf("Expected error containing '%s', got %v", msg, e)
+		}
+	}
+}
+
+func TestLoadURLListFromStringOrList(t *testing.T) {
+	info := "4:infod6:lengthi1e4:name1:x12:piece lengthi1e6:pieces20:aaaaaaaaaaaaaaaaaaaae"
+	for input, expected := range map[string]URLList{
+		"d" + info + "8:url-list5:http:e":           {"http:"},
+		"d" + info + "8:url-list0:e":                nil,
+		"d" + info + "8:url-listl5:http:6:https:ee": {"http:", "https:"},
+	} {
+		m, e := Load(strings.NewReader(input))
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !reflect.DeepEqual(m.URLList, expected) {
+			t.Fatalf("Expected %q, got %q", expected, m.URLList)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package metainfo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Bitfield holds one bit per piece, the first piece in the
+// highest bit of the first byte, as in the peer wire protocol.
+type Bitfield []byte
+
+func newBitfield(n int) Bitfield {
+	return make(Bitfield, (n+7)/8)
+}
+
+// Has reports whether bit i is set.
+func (b Bitfield) Has(i int) bool {
+	return b[i/8]&(0x80>>uint(i%8)) != 0
+}
+
+// Set sets bit i.
+func (b Bitfield) Set(i int) {
+	b[i/8] |= 0x80 >> uint(i%8)
+}
+
+// VerifyResult is the outcome of checking data against a torrent.
+type VerifyResult struct {
+	// Pieces has a bit set for every piece whose data is good.
+	// Pieces are numbered as in the v1 piece list when the torrent
+	// has one and, for v2 torrents, consecutively through the
+	// pieces of each non-empty file in file tree order.
+	Pieces    Bitfield
+	NumPieces int
+	Files     []FileStatus // in the order of MetaInfo.Files, pad files excluded
+}
+
+// FileStatus summarises the pieces covering one file.
+type FileStatus struct {
+	Path       []string
+	Length     int64
+	Pieces     int // pieces holding data of the file
+	GoodPieces int
+	Missing    bool
+}
+
+// Complete reports whether all of the file's data is good.
+func (s *FileStatus) Complete() bool {
+	return !s.Missing && s.GoodPieces == s.Pieces
+}
+
+// Verifier checks data on disk against the hashes of a torrent.
+type Verifier struct {
+	Workers int // number of hashing goroutines, defaults to GOMAXPROCS
+
+	// Progress, when set, is called after each piece is checked,
+	// from the hashing goroutines but never concurrently.
+	Progress func(done, total int)
+}
+
+// Verify checks the data of m stored under root with a default Verifier.
+func Verify(ctx context.Context, m *MetaInfo, root string) (*VerifyResult, error) {
+	return (&Verifier{}).Verify(ctx, m, root)
+}
+
+// Verify reads the files of m, which are expected under root as
+// root/name/path for multi file torrents and root/name otherwise, and
+// checks every piece against the v1 piece hashes or, for v2 only
+// torrents, the pieces roots and piece layers. Missing or short files
+// only make their pieces bad; an error is returned when ctx is done
+// before all pieces are checked, or when m is not well formed.
+func (v *Verifier) Verify(ctx context.Context, m *MetaInfo, root string) (*VerifyResult, error) {
+	if e := m.validate(); e != nil {
+		return nil, e
+	}
+	c := &pieceChecker{m: m, root: root, files: m.Files(), v1: m.Info.IsV1()}
+	for _, f := range c.files {
+		for _, element := range f.Path {
+			if e := validatePathElement(element); e != nil {
+				return nil, e
+			}
+		}
+	}
+	var numPieces int
+	// Pieces are never longer than the data they cover, which
+	// keeps a bogus piece length from sizing the read buffers.
+	var bufSize int64
+	if c.v1 {
+		numPieces = m.Info.NumPieces()
+		if numPieces > 0 {
+			bufSize = m.Info.PieceSize(0)
+		}
+	} else {
+		c.layers = make(map[int][]byte)
+		for i, f := range c.files {
+			n := int((f.Length + m.Info.PieceLength - 1) / m.Info.PieceLength)
+			for p := 0; p < n; p++ {
+				c.v2Pieces = append(c.v2Pieces, v2Piece{file: i, piece: p})
+			}
+			if f.Length > m.Info.PieceLength {
+				c.layers[i] = m.PieceLayers[string(f.PiecesRoot)]
+			}
+			if f.Length > bufSize {
+				bufSize = f.Length
+			}
+		}
+		numPieces = len(c.v2Pieces)
+		if bufSize > m.Info.PieceLength {
+			bufSize = m.Info.PieceLength
+		}
+	}
+
+	workers := v.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	good := make([]bool, numPieces)
+	jobs := make(chan int)
+	var mu sync.Mutex
+	done := 0
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf []byte
+			for i := range jobs {
+				if buf == nil {
+					buf = make([]byte, bufSize)
+				}
+				good[i] = c.check(i, buf)
+				if v.Progress != nil {
+					mu.Lock()
+					done++
+					v.Progress(done, numPieces)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+feed:
+	for i := 0; i < numPieces; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if e := ctx.Err(); e != nil {
+		return nil, e
+	}
+
+	result := &VerifyResult{Pieces: newBitfield(numPieces), NumPieces: numPieces}
+	for i, ok := range good {
+		if ok {
+			result.Pieces.Set(i)
+		}
+	}
+	result.Files = c.summarise(good)
+	return result, nil
+}
+
+// v2Piece locates a piece of a v2 torrent.
+type v2Piece struct {
+	file  int
+	piece int
+}
+
+// pieceChecker reads and hashes single pieces.
+type pieceChecker struct {
+	m        *MetaInfo
+	root     string
+	files    []FileEntry
+	v1       bool
+	v2Pieces []v2Piece
+	layers   map[int][]byte // piece layers by file index
+}
+
+func (c *pieceChecker) osPath(f FileEntry) string {
+	return filepath.Join(c.root, filepath.Join(f.Path...))
+}
+
+// check reports whether piece i is good, using buf to read it.
+func (c *pieceChecker) check(i int, buf []byte) bool {
+	pieceLength := c.m.Info.PieceLength
+	if c.v1 {
+		start := int64(i) * pieceLength
+		data := buf[:c.m.Info.PieceSize(i)]
+		if !c.readV1(start, data) {
+			return false
+		}
+		sum := sha1.Sum(data)
+		return bytes.Equal(sum[:], c.m.Info.PieceHash(i))
+	}
+	p := c.v2Pieces[i]
+	f := c.files[p.file]
+	start := int64(p.piece) * pieceLength
+	n := f.Length - start
+	if n > pieceLength {
+		n = pieceLength
+	}
+	data := buf[:n]
+	if !c.readFile(f, start, data) {
+		return false
+	}
+	if f.Length <= pieceLength {
+		root := blockTreeRoot(data, n)
+		return bytes.Equal(root[:], f.PiecesRoot)
+	}
+	root := blockTreeRoot(data, pieceLength)
+	layer := c.layers[p.file]
+	offset := p.piece * sha256.Size
+	return offset+sha256.Size <= len(layer) && bytes.Equal(root[:], layer[offset:offset+sha256.Size])
+}
+
+// readV1 fills data from the concatenated files starting at start.
+func (c *pieceChecker) readV1(start int64, data []byte) bool {
+	i := sort.Search(len(c.files), func(i int) bool {
+		return c.files[i].Offset+c.files[i].Length > start
+	})
+	for len(data) > 0 && i < len(c.files) {
+		f := c.files[i]
+		off := start - f.Offset
+		n := f.Length - off
+		if n > int64(len(data)) {
+			n = int64(len(data))
+		}
+		if !c.readFile(f, off, data[:n]) {
+			return false
+		}
+		data = data[n:]
+		start += n
+		i++
+	}
+	return len(data) == 0
+}
+
+// readFile fills data from f starting at off; pad files read as zeros.
+func (c *pieceChecker) readFile(f FileEntry, off int64, data []byte) bool {
+	if f.Padding {
+		for i := range data {
+			data[i] = 0
+		}
+		return true
+	}
+	file, e := os.Open(c.osPath(f))
+	if e != nil {
+		return false
+	}
+	defer file.Close()
+	_, e = file.ReadAt(data, off)
+	return e == nil
+}
+
+// summarise counts the good pieces of each file.
+func (c *pieceChecker) summarise(good []bool) []FileStatus {
+	var files []FileStatus
+	pieceLength := c.m.Info.PieceLength
+	v2Next := 0
+	for _, f := range c.files {
+		if f.Padding {
+			continue
+		}
+		s := FileStatus{Path: f.Path, Length: f.Length}
+		var first, end int
+		if c.v1 {
+			first = int(f.Offset / pieceLength)
+			end = int((f.Offset + f.Length + pieceLength - 1) / pieceLength)
+			if f.Length == 0 {
+				end = first
+			}
+		} else {
+			first = v2Next
+			end = first + int((f.Length+pieceLength-1)/pieceLength)
+			v2Next = end
+		}
+		for i := first; i < end; i++ {
+			s.Pieces++
+			if good[i] {
+				s.GoodPieces++
+			}
+		}
+		if _, e := os.Stat(c.osPath(f)); e != nil {
+			s.Missing = true
+		}
+		files = append(files, s)
+	}
+	return files
+}
@@ -0,0 +1,128 @@
+package metainfo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func fileStatus(t *testing.T, result *VerifyResult, path string) FileStatus {
+	for _, s := range result.Files {
+		if joinPath(s.Path[1:]) == path {
+			return s
+		}
+	}
+	t.Fatalf("No status of '%s'", path)
+	return FileStatus{}
+}
+
+func TestVerify(t *testing.T) {
+	for _, version := range []Version{V1, V2, Hybrid} {
+		dir := writeTestFiles(t, builderFiles)
+		defer os.RemoveAll(dir)
+		m, e := (&Builder{Version: version, PieceLength: BlockSize}).Build(dir)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		calls, lastDone, lastTotal := 0, 0, 0
+		v := &Verifier{Workers: 3, Progress: func(done, total int) {
+			calls++
+			lastDone, lastTotal = done, total
+		}}
+		result, e := v.Verify(context.Background(), m, filepath.Dir(dir))
+		if e != nil {
+			t.Fatal(e)
+		}
+		if n := result.NumPieces; n == 0 || calls != n || lastDone != n || lastTotal != n {
+			t.Fatalf("Expected %d progress calls, got %d ending at %d/%d", n, calls, lastDone, lastTotal)
+		}
+		for i := 0; i < result.NumPieces; i++ {
+			if !result.Pieces.Has(i) {
+				t.Fatalf("%d: expected piece %d to be good", version, i)
+			}
+		}
+		if len(result.Files) != len(builderFiles) {
+			t.Fatalf("Expected status of %d files, got %d", len(builderFiles), len(result.Files))
+		}
+		for _, s := range result.Files {
+			if !s.Complete() {
+				t.Fatalf("%d: expected '%s' to be complete, got %+v", version, joinPath(s.Path), s)
+			}
+		}
+
+		corrupted := append([]byte{}, builderFiles["sub/b.bin"]...)
+		corrupted[BlockSize+1] ^= 1
+		if e := ioutil.WriteFile(filepath.Join(dir, "sub", "b.bin"), corrupted, 0644); e != nil {
+			t.Fatal(e)
+		}
+		if e := os.Remove(filepath.Join(dir, "zzz", "d.data")); e != nil {
+			t.Fatal(e)
+		}
+		result, e = Verify(context.Background(), m, filepath.Dir(dir))
+		if e != nil {
+			t.Fatal(e)
+		}
+		if s := fileStatus(t, result, "sub/b.bin"); s.Complete() || s.GoodPieces != s.Pieces-1 {
+			t.Fatalf("%d: expected one bad piece, got %+v", version, s)
+		}
+		if s := fileStatus(t, result, "zzz/d.data"); s.Complete() || !s.Missing || s.GoodPieces != 0 {
+			t.Fatalf("%d: expected missing file, got %+v", version, s)
+		}
+		if s := fileStatus(t, result, "a.txt"); !s.Complete() || s.Pieces != 1 {
+			t.Fatalf("%d: expected complete file, got %+v", version, s)
+		}
+		if s := fileStatus(t, result, "sub/empty"); !s.Complete() {
+			t.Fatalf("%d: expected complete empty file, got %+v", version, s)
+		}
+	}
+}
+
+func TestVerifyStopsWhenCancelled(t *testing.T) {
+	dir := writeTestFiles(t, builderFiles)
+	defer os.RemoveAll(dir)
+	m, e := (&Builder{PieceLength: BlockSize}).Build(dir)
+	if e != nil {
+		t.Fatal(e)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, e := Verify(ctx, m, filepath.Dir(dir)); e != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", e)
+	}
+}
+
+func TestVerifyRejectsEscapingPaths(t *testing.T) {
+	m := &MetaInfo{Info: Info{Name: "..", PieceLength: 1, Length: 1, Pieces: make([]byte, 20)}}
+	if _, e := Verify(context.Background(), m, "."); e == nil {
+		t.Fatalf("Expected error for path outside root")
+	}
+}
+
+func TestBitfield(t *testing.T) {
+	b := newBitfield(10)
+	b.Set(0)
+	b.Set(9)
+	if len(b) != 2 || b[0] != 0x80 || b[1] != 0x40 || !b.Has(9) || b.Has(8) {
+		t.Fatalf("Unexpected bitfield %08b", b)
+	}
+}
+
+func TestVerifyRejectsMalformedMetaInfo(t *testing.T) {
+	for _, m := range []*MetaInfo{
+		{Info: Info{Name: "x", PieceLength: 1, Length: 1, Pieces: make([]byte, 3*PieceHashSize)}},
+		{Info: Info{Name: "x", PieceLength: 0, Length: 1, Pieces: make([]byte, PieceHashSize)}},
+		{Info: Info{Name: "x", PieceLength: BlockSize, MetaVersion: 2, FileTree: FileTree{
+			"x": {File: &FileV2{Length: 2 * BlockSize, PiecesRoot: make([]byte, 32)}},
+		}}},
+	} {
+		if _, e := Verify(context.Background(), m, "."); e == nil {
+			t.Fatalf("Expected error for %+v", m.Info)
+		}
+		if e := m.VerifyPieceLayers(); e == nil {
+			t.Fatalf("Expected error for %+v", m.Info)
+		}
+	}
+}
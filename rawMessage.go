@@ -0,0 +1,25 @@
+package bencoding
+
+import (
+	"errors"
+)
+
+// RawMessage is a raw encoded bencode value.
+// It implements Marshaler and Unmarshaler and can be used
+// to delay bencode decoding, or to pass a value (for example
+// the info dictionary of a torrent) through byte for byte.
+type RawMessage []byte
+
+// MarshalBencode returns m as the bencoding of m.
+func (m RawMessage) MarshalBencode() ([]byte, error) {
+	return m, nil
+}
+
+// UnmarshalBencode sets *m to a copy of data.
+func (m *RawMessage) UnmarshalBencode(data []byte) error {
+	if m == nil {
+		return errors.New("bencoding.RawMessage: UnmarshalBencode on nil pointer")
+	}
+	*m = append((*m)[0:0], data...)
+	return nil
+}
@@ -0,0 +1,82 @@
+package bencoding
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// field is a struct field stored as a dictionary entry.
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
+	required  bool
+	asString  bool
+	settable  bool
+}
+
+// structFields describes how a struct type maps onto a dictionary:
+// its fields sorted by key, and an index from key to field.
+type structFields struct {
+	list   []field
+	byName map[string]int
+}
+
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+// cachedTypeFields is like typeFields but uses a cache
+// to avoid repeated work.
+func cachedTypeFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.(*structFields)
+}
+
+// typeFields returns the fields of struct type t which
+// are stored in a dictionary, sorted by their keys.
+func typeFields(t reflect.Type) *structFields {
+	v := reflect.Zero(t)
+	fields := &structFields{byName: make(map[string]int)}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key, opts := extractFieldKeyAndOptions(v, sf.Name)
+		if key == "" {
+			continue
+		}
+		fields.list = append(fields.list, field{
+			name:      key,
+			index:     i,
+			omitEmpty: opts.Contains("omitempty"),
+			required:  opts.Contains("required"),
+			asString:  opts.Contains("string"),
+			settable:  sf.PkgPath == "",
+		})
+	}
+	sort.Sort(fieldsByName(fields.list))
+	for i, f := range fields.list {
+		if _, dup := fields.byName[f.name]; !dup {
+			fields.byName[f.name] = i
+		}
+	}
+	return fields
+}
+
+type fieldsByName []field
+
+func (f fieldsByName) Len() int {
+	return len(f)
+}
+
+func (f fieldsByName) Less(i, j int) bool {
+	if f[i].name != f[j].name {
+		return f[i].name < f[j].name
+	}
+	return f[i].index < f[j].index
+}
+
+func (f fieldsByName) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
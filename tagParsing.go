@@ -3,6 +3,7 @@ package bencoding
 import (
 	"reflect"
 	"regexp"
+	"strings"
 )
 
 func tagForFieldNamed(value reflect.Value, name string) string {
@@ -13,10 +14,10 @@ func tagForFieldNamed(value reflect.Value, name string) string {
 	return string(field.Tag)
 }
 
+var fieldRegexp = regexp.MustCompile(`bencoding:"([^"]*)"`)
+
 func parseTag(tag string) *string {
-	const fieldRegexp = `bencoding:"([\w- ]*)"`
-	reg := regexp.MustCompile(fieldRegexp)
-	if matches := reg.FindStringSubmatch(tag); len(matches) > 2 {
+	if matches := fieldRegexp.FindStringSubmatch(tag); len(matches) > 2 {
 		panic("regexp for parsing fields seems to be wrong -- more then two groups returned")
 	} else if len(matches) == 2 {
 		return &matches[1]
@@ -25,14 +26,54 @@ func parseTag(tag string) *string {
 	}
 }
 
+// tagOptions is the string following a comma in a struct field's
+// bencoding tag, or the empty string.
+type tagOptions string
+
+// splitTag splits a bencoding tag into the key and its options.
+func splitTag(tag string) (string, tagOptions) {
+	if i := strings.Index(tag, ","); i != -1 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, tagOptions("")
+}
+
+// Contains reports whether a comma-separated list of options
+// contains a particular option.
+func (o tagOptions) Contains(option string) bool {
+	s := string(o)
+	for s != "" {
+		var next string
+		if i := strings.Index(s, ","); i >= 0 {
+			s, next = s[:i], s[i+1:]
+		}
+		if s == option {
+			return true
+		}
+		s = next
+	}
+	return false
+}
+
 func extractFieldOptions(v reflect.Value, name string) string {
+	key, _ := extractFieldKeyAndOptions(v, name)
+	return key
+}
+
+// extractFieldKeyAndOptions returns the dictionary key under which
+// the field is stored, or "" when the field is ignored, together with
+// the options from the field's bencoding tag.
+func extractFieldKeyAndOptions(v reflect.Value, name string) (string, tagOptions) {
 	tag := tagForFieldNamed(v, name)
 	bencodingTag := parseTag(tag)
 	if bencodingTag == nil {
-		return name
+		return name, ""
 	} else if *bencodingTag == "" || *bencodingTag == "-" {
-		return ""
-	} else {
-		return *bencodingTag
+		return "", ""
+	}
+	key, opts := splitTag(*bencodingTag)
+	if key == "" {
+		key = name
 	}
+	return key, opts
 }
@@ -0,0 +1,197 @@
+package bencoding
+
+import (
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// A Token holds a value of one of these types:
+//
+//	Delim, for the start and end of lists and dictionaries
+//	int64, for bencode integers
+//	[]byte, for bencode strings
+type Token interface{}
+
+// A Delim is a bencode list or dictionary delimiter.
+type Delim byte
+
+const (
+	DictStart Delim = 'd' // start of a dictionary
+	ListStart Delim = 'l' // start of a list
+	End       Delim = 'e' // end of a list or dictionary
+)
+
+func (d Delim) String() string {
+	return string(d)
+}
+
+// tokenLevel tracks a list or dictionary opened by Token.
+type tokenLevel struct {
+	dict    bool
+	n       int    // number of keys and values read so far
+	prevKey []byte // previous key, for canonical mode
+}
+
+func (l *tokenLevel) wantsKey() bool {
+	return l.dict && l.n%2 == 0
+}
+
+// Token returns the next bencode token in the input stream.
+// At the end of the input stream, Token returns nil, io.EOF.
+//
+// Token guarantees that the delimiters it returns are properly
+// nested and that dictionary keys are strings. Strings are
+// returned as slices of bytes; use Skip to move past a value
+// (for example a huge "pieces" string) without reading it
+// into memory.
+//
+// Token and Decode may be interleaved: Decode consumes the
+// next complete value, which must not be a dictionary key.
+func (d *Decoder) Token() (Token, error) {
+	td := d.torrentDecoder
+	if len(d.tokenState) == 0 {
+		if _, e := td.b.Peek(1); e == io.EOF {
+			return nil, e
+		}
+	}
+	b, e := td.peek()
+	if e != nil {
+		return nil, e
+	}
+	if b == 'e' {
+		return d.tokenEnd()
+	}
+	if len(d.tokenState) > 0 && d.tokenState[len(d.tokenState)-1].wantsKey() {
+		return d.tokenKey()
+	}
+	switch b {
+	case 'd', 'l':
+		if e := d.tokenValueStarted(); e != nil {
+			return nil, e
+		}
+		td.b.ReadByte()
+		if e := td.enterContainer(); e != nil {
+			return nil, e
+		}
+		d.tokenState = append(d.tokenState, tokenLevel{dict: b == 'd'})
+		return Delim(b), nil
+	case 'i':
+		offset := td.b.offset
+		data, e := td.readIntDigits()
+		if e != nil {
+			return nil, e
+		}
+		i, e := strconv.ParseInt(string(data), 10, 64)
+		if e != nil {
+			return nil, td.numberError(data, e, reflect.TypeOf(i), offset)
+		}
+		if e := d.tokenValueStarted(); e != nil {
+			return nil, e
+		}
+		return i, nil
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		_, content, e := td.readString()
+		if e != nil {
+			return nil, e
+		}
+		if e := d.tokenValueStarted(); e != nil {
+			return nil, e
+		}
+		return content, nil
+	}
+	return nil, td.syntaxError("invalid character '" + string(b) + "' looking for beginning of value")
+}
+
+func (d *Decoder) tokenEnd() (Token, error) {
+	td := d.torrentDecoder
+	if len(d.tokenState) == 0 {
+		return nil, td.syntaxError("unexpected end of list or dictionary")
+	}
+	top := d.tokenState[len(d.tokenState)-1]
+	if top.dict && !top.wantsKey() {
+		return nil, td.syntaxError("missing value for dictionary key '" + string(top.prevKey) + "'")
+	}
+	td.b.ReadByte()
+	td.leaveContainer()
+	d.tokenState = d.tokenState[:len(d.tokenState)-1]
+	return End, nil
+}
+
+func (d *Decoder) tokenKey() (Token, error) {
+	td := d.torrentDecoder
+	if b, e := td.peek(); e != nil {
+		return nil, e
+	} else if b < '0' || b > '9' {
+		return nil, td.syntaxError("dictionary key must be a string")
+	}
+	if e := td.countElement(); e != nil {
+		return nil, e
+	}
+	top := &d.tokenState[len(d.tokenState)-1]
+	offset := td.b.offset
+	_, key, e := td.readString()
+	if e != nil {
+		return nil, e
+	}
+	if e := td.checkKeyOrder(top.prevKey, key, top.n == 0, offset); e != nil {
+		return nil, e
+	}
+	top.prevKey = key
+	top.n++
+	return key, nil
+}
+
+// tokenValueStarted records that a value was read
+// in the innermost open list or dictionary.
+func (d *Decoder) tokenValueStarted() error {
+	if len(d.tokenState) == 0 {
+		return nil
+	}
+	top := &d.tokenState[len(d.tokenState)-1]
+	top.n++
+	if !top.dict {
+		return d.torrentDecoder.countElement()
+	}
+	return nil
+}
+
+// tokenValue prepares for reading a complete value with
+// Decode or Skip while inside lists or dictionaries opened
+// by Token.
+func (d *Decoder) tokenValue() error {
+	if len(d.tokenState) == 0 {
+		return nil
+	}
+	top := &d.tokenState[len(d.tokenState)-1]
+	if b, e := d.torrentDecoder.peek(); e != nil {
+		return e
+	} else if b == 'e' {
+		return d.torrentDecoder.syntaxError("no value before end of list or dictionary")
+	} else if top.wantsKey() {
+		return d.torrentDecoder.syntaxError("dictionary key expected, use Token to read it")
+	}
+	top.n++
+	if !top.dict {
+		return d.torrentDecoder.countElement()
+	}
+	return nil
+}
+
+// More reports whether there is another element in the
+// current list or dictionary, or, at the top level,
+// whether there is more input.
+func (d *Decoder) More() bool {
+	b, e := d.torrentDecoder.b.Peek(1)
+	return e == nil && b[0] != 'e'
+}
+
+// Skip reads the next complete value and discards it. String
+// contents are skipped without being held in memory.
+func (d *Decoder) Skip() error {
+	if e := d.tokenValue(); e != nil {
+		return e
+	}
+	defer d.torrentDecoder.restoreDepth(d.torrentDecoder.depth)
+	return d.torrentDecoder.skipValue()
+}
@@ -0,0 +1,97 @@
+package bencoding
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	d := NewStringDecoder("d8:announce3:foo4:infod6:lengthi-42e6:pieces4:abcde4:listli1eleee")
+	expected := []Token{
+		DictStart,
+		[]byte("announce"), []byte("foo"),
+		[]byte("info"), DictStart,
+		[]byte("length"), int64(-42),
+		[]byte("pieces"), []byte("abcd"),
+		End,
+		[]byte("list"), ListStart, int64(1), ListStart, End, End,
+		End,
+	}
+	for i, want := range expected {
+		if got, e := d.Token(); e != nil {
+			t.Fatalf("Token %d: unexpected error '%v'", i, e)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Token %d: expected '%v' got '%v'", i, want, got)
+		}
+	}
+	if tok, e := d.Token(); e != io.EOF {
+		t.Fatalf("Expected io.EOF, got '%v' and '%v'", tok, e)
+	}
+}
+
+func TestTokensWithSkipDecodeAndMore(t *testing.T) {
+	type Info struct {
+		Length int64 `bencoding:"length"`
+	}
+	d := NewStringDecoder("d4:infod6:lengthi7ee6:pieces10:0123456789e")
+	if tok, e := d.Token(); e != nil || tok != DictStart {
+		t.Fatalf("Expected DictStart, got '%v' and '%v'", tok, e)
+	}
+	var info Info
+	for d.More() {
+		key, e := d.Token()
+		if e != nil {
+			t.Fatal(e)
+		}
+		switch string(key.([]byte)) {
+		case "info":
+			if e := d.Decode(&info); e != nil {
+				t.Fatal(e)
+			}
+		default:
+			if e := d.Skip(); e != nil {
+				t.Fatal(e)
+			}
+		}
+	}
+	if tok, e := d.Token(); e != nil || tok != End {
+		t.Fatalf("Expected End, got '%v' and '%v'", tok, e)
+	}
+	if info.Length != 7 {
+		t.Fatalf("Expected length 7, got '%v'", info.Length)
+	}
+	if d.More() {
+		t.Fatalf("Expected no more input")
+	}
+}
+
+func TestTokenErrors(t *testing.T) {
+	data := []string{
+		"e",
+		"di1ei2ee",
+		"d1:ae",
+		"lx",
+		"d1:bi1e1:ai2ee",
+		"i12",
+	}
+	for _, s := range data {
+		d := NewStringDecoder(s)
+		d.DisallowNonCanonical()
+		var e error
+		for e == nil {
+			_, e = d.Token()
+		}
+		var se *SyntaxError
+		if !errors.As(e, &se) {
+			t.Fatalf("Expected SyntaxError for '%s', got '%v'", s, e)
+		}
+	}
+	d := NewStringDecoder("d1:ai1ee")
+	d.Token()
+	var s string
+	if e := d.Decode(&s); e == nil {
+		t.Fatalf("Expected Decode of a dictionary key to fail")
+	}
+}
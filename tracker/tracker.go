@@ -0,0 +1,181 @@
+// Package tracker decodes and encodes the responses of HTTP trackers
+// to announce requests as described by BEP 3
+// (http://bittorrent.org/beps/bep_0003.html), including the compact
+// peer lists of BEP 23 and the IPv6 peers of BEP 7.
+package tracker
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/tumdum/bencoding"
+)
+
+const (
+	compactPeerSize  = 6  // IPv4 address and port
+	compactPeer6Size = 18 // IPv6 address and port
+)
+
+// AnnounceResponse is the dictionary an HTTP tracker responds with
+// to an announce request. A tracker that rejects the request only
+// sets FailureReason.
+type AnnounceResponse struct {
+	FailureReason  string `bencoding:"failure reason,omitempty"`
+	WarningMessage string `bencoding:"warning message,omitempty"`
+	Interval       int64  `bencoding:"interval"`               // seconds to wait between announces
+	MinInterval    int64  `bencoding:"min interval,omitempty"` // seconds to wait at least
+	TrackerID      string `bencoding:"tracker id,omitempty"`   // to be sent back with later announces
+	Complete       int64  `bencoding:"complete"`               // number of seeders
+	Incomplete     int64  `bencoding:"incomplete"`             // number of leechers
+	Peers          Peers  `bencoding:"peers"`
+	Peers6         Peers6 `bencoding:"peers6,omitempty"`
+}
+
+// MarshalBencode encodes a failure response as just its failure
+// reason and warning message; the counts and peers, which a success
+// response always carries, would be meaningless next to it.
+func (r AnnounceResponse) MarshalBencode() ([]byte, error) {
+	if r.FailureReason != "" {
+		return bencoding.Marshal(struct {
+			FailureReason  string `bencoding:"failure reason"`
+			WarningMessage string `bencoding:"warning message,omitempty"`
+		}{r.FailureReason, r.WarningMessage})
+	}
+	type response AnnounceResponse // without the MarshalBencode method
+	return bencoding.Marshal(response(r))
+}
+
+// A FailureError is returned by ParseAnnounceResponse and
+// DecodeAnnounceResponse when the tracker rejected the announce.
+type FailureError struct {
+	Reason string
+}
+
+func (e *FailureError) Error() string {
+	return "tracker failure: " + e.Reason
+}
+
+// ParseAnnounceResponse decodes the body of a tracker's response. When
+// the response holds a failure reason, it is returned together with a
+// *FailureError.
+func ParseAnnounceResponse(data []byte) (*AnnounceResponse, error) {
+	return DecodeAnnounceResponse(bytes.NewReader(data))
+}
+
+// DecodeAnnounceResponse reads a tracker's response from r, as
+// ParseAnnounceResponse does.
+func DecodeAnnounceResponse(r io.Reader) (*AnnounceResponse, error) {
+	resp := &AnnounceResponse{}
+	if e := bencoding.NewDecoder(r).Decode(resp); e != nil {
+		return nil, e
+	}
+	if resp.FailureReason != "" {
+		return resp, &FailureError{Reason: resp.FailureReason}
+	}
+	return resp, nil
+}
+
+// Peer is a peer returned by a tracker. Peers from compact lists
+// have no ID.
+type Peer struct {
+	ID   string `bencoding:"peer id,omitempty"`
+	IP   string `bencoding:"ip"` // IP address or DNS name
+	Port uint16 `bencoding:"port"`
+}
+
+// Addr returns the peer's address in host:port form.
+func (p *Peer) Addr() string {
+	return net.JoinHostPort(p.IP, strconv.Itoa(int(p.Port)))
+}
+
+// Peers is the "peers" list of an announce response. It decodes from
+// either a list of dictionaries or a string of compact 6 byte IPv4
+// peers, and encodes in the compact form when every peer has an IPv4
+// address and no ID.
+type Peers []Peer
+
+func (p *Peers) UnmarshalBencode(data []byte) error {
+	peers, e := unmarshalPeers(data, compactPeerSize)
+	if e != nil {
+		return e
+	}
+	*p = peers
+	return nil
+}
+
+func (p Peers) MarshalBencode() ([]byte, error) {
+	return marshalPeers(p, compactPeerSize)
+}
+
+// Peers6 is the "peers6" list of an announce response, which holds
+// compact 18 byte IPv6 peers. Like Peers it also accepts a list of
+// dictionaries, and encodes as one unless every peer has an IPv6
+// address and no ID.
+type Peers6 []Peer
+
+func (p *Peers6) UnmarshalBencode(data []byte) error {
+	peers, e := unmarshalPeers(data, compactPeer6Size)
+	if e != nil {
+		return e
+	}
+	*p = peers
+	return nil
+}
+
+func (p Peers6) MarshalBencode() ([]byte, error) {
+	return marshalPeers(p, compactPeer6Size)
+}
+
+func unmarshalPeers(data []byte, size int) ([]Peer, error) {
+	var compact []byte
+	if e := bencoding.Unmarshal(data, &compact); e != nil {
+		var list []Peer
+		if e := bencoding.Unmarshal(data, &list); e != nil {
+			return nil, e
+		}
+		return list, nil
+	}
+	if len(compact)%size != 0 {
+		return nil, errors.New("compact peers length " + strconv.Itoa(len(compact)) +
+			" is not a multiple of " + strconv.Itoa(size))
+	}
+	peers := make([]Peer, 0, len(compact)/size)
+	for off := 0; off < len(compact); off += size {
+		peer := compact[off : off+size]
+		ip := net.IP(peer[:size-2])
+		port := uint16(peer[size-2])<<8 | uint16(peer[size-1])
+		peers = append(peers, Peer{IP: ip.String(), Port: port})
+	}
+	return peers, nil
+}
+
+func marshalPeers(peers []Peer, size int) ([]byte, error) {
+	compact := make([]byte, 0, len(peers)*size)
+	for _, p := range peers {
+		ip := compactIP(p, size)
+		if ip == nil {
+			return bencoding.Marshal(peers)
+		}
+		compact = append(append(compact, ip...), byte(p.Port>>8), byte(p.Port))
+	}
+	return bencoding.Marshal(compact)
+}
+
+// compactIP returns the address of p in the compact form of the
+// given size, or nil if p cannot be written in it.
+func compactIP(p Peer, size int) net.IP {
+	ip := net.ParseIP(p.IP)
+	if p.ID != "" || ip == nil {
+		return nil
+	}
+	if size == compactPeerSize {
+		return ip.To4()
+	}
+	if ip.To4() != nil {
+		return nil
+	}
+	return ip.To16()
+}
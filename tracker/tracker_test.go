@@ -0,0 +1,124 @@
+package tracker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tumdum/bencoding"
+)
+
+func TestParseCompactResponse(t *testing.T) {
+	data := "d8:completei5e10:incompletei2e8:intervali1800e12:min intervali60e" +
+		"5:peers12:\x0a\x00\x00\x01\x1a\xe1\xc0\xa8\x01\x02\x00\x50" +
+		"6:peers618:\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\xc8\xd5" +
+		"10:tracker id3:abc15:warning message4:slowe"
+	resp, e := ParseAnnounceResponse([]byte(data))
+	if e != nil {
+		t.Fatal(e)
+	}
+	expected := &AnnounceResponse{
+		WarningMessage: "slow",
+		Interval:       1800,
+		MinInterval:    60,
+		TrackerID:      "abc",
+		Complete:       5,
+		Incomplete:     2,
+		Peers:          Peers{{IP: "10.0.0.1", Port: 6881}, {IP: "192.168.1.2", Port: 80}},
+		Peers6:         Peers6{{IP: "2001:db8::1", Port: 51413}},
+	}
+	if !reflect.DeepEqual(resp, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, resp)
+	}
+	if addr := resp.Peers6[0].Addr(); addr != "[2001:db8::1]:51413" {
+		t.Fatalf("Unexpected address '%s'", addr)
+	}
+	encoded, e := bencoding.Marshal(resp)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(encoded) != data {
+		t.Fatalf("Expected '%q', got '%q'", data, encoded)
+	}
+}
+
+func TestParseDictionaryPeers(t *testing.T) {
+	data := "d8:completei0e10:incompletei1e8:intervali900e5:peersl" +
+		"d2:ip9:127.0.0.17:peer id20:aaaaaaaaaaaaaaaaaaaa4:porti6881ee" +
+		"d2:ip11:example.com4:porti80ee" +
+		"d2:ip3:::14:porti1eeee"
+	resp, e := ParseAnnounceResponse([]byte(data))
+	if e != nil {
+		t.Fatal(e)
+	}
+	expected := Peers{
+		{ID: strings.Repeat("a", 20), IP: "127.0.0.1", Port: 6881},
+		{IP: "example.com", Port: 80},
+		{IP: "::1", Port: 1},
+	}
+	if !reflect.DeepEqual(resp.Peers, expected) || resp.Peers6 != nil || resp.Interval != 900 {
+		t.Fatalf("Unexpected response %+v", resp)
+	}
+	encoded, e := bencoding.Marshal(resp)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(encoded) != data {
+		t.Fatalf("Expected '%q', got '%q'", data, encoded)
+	}
+}
+
+func TestParseFailure(t *testing.T) {
+	resp, e := ParseAnnounceResponse([]byte("d14:failure reason12:unregisterede"))
+	if f, ok := e.(*FailureError); !ok || f.Reason != "unregistered" || resp == nil || resp.FailureReason != f.Reason {
+		t.Fatalf("Expected failure error, got %v", e)
+	}
+}
+
+func TestMarshalFailureResponse(t *testing.T) {
+	for resp, expected := range map[*AnnounceResponse]string{
+		{FailureReason: "nope"}:                   "d14:failure reason4:nopee",
+		{FailureReason: "nope", Interval: 60}:     "d14:failure reason4:nopee",
+		{FailureReason: "x", WarningMessage: "y"}: "d14:failure reason1:x15:warning message1:ye",
+		{}: "d8:completei0e10:incompletei0e8:intervali0e5:peers0:e",
+	} {
+		if encoded, e := bencoding.Marshal(*resp); e != nil || string(encoded) != expected {
+			t.Fatalf("Expected %q, got %q (%v)", expected, encoded, e)
+		}
+		if encoded, e := bencoding.Marshal(resp); e != nil || string(encoded) != expected {
+			t.Fatalf("Expected %q through a pointer, got %q (%v)", expected, encoded, e)
+		}
+	}
+}
+
+func TestParseRejectsBadPeers(t *testing.T) {
+	for data, msg := range map[string]string{
+		"d5:peers5:12345e":                  "not a multiple of 6",
+		"d6:peers66:123456e":                "not a multiple of 18",
+		"d5:peersi1ee":                      "",
+		"d5:peersld2:ip1:x4:porti70000eeee": "",
+		"d5:peers6:123456":                  "unexpected end of input",
+	} {
+		if _, e := ParseAnnounceResponse([]byte(data)); e == nil || !strings.Contains(e.Error(), msg) {
+			t.Fatalf("%q: expected error containing '%s', got %v", data, msg, e)
+		}
+	}
+}
+
+func TestMarshalPeers(t *testing.T) {
+	for _, c := range []struct {
+		peers    interface{}
+		expected string
+	}{
+		{Peers{{IP: "1.2.3.4", Port: 258}}, "6:\x01\x02\x03\x04\x01\x02"},
+		{Peers{{IP: "::ffff:1.2.3.4", Port: 1}}, "6:\x01\x02\x03\x04\x00\x01"},
+		{Peers{{IP: "::1", Port: 1}}, "ld2:ip3:::14:porti1eee"},
+		{Peers6{{IP: "1.2.3.4", Port: 1}}, "ld2:ip7:1.2.3.44:porti1eee"},
+		{Peers6{{IP: "host", Port: 1}}, "ld2:ip4:host4:porti1eee"},
+		{Peers(nil), "0:"},
+	} {
+		if encoded, e := bencoding.Marshal(c.peers); e != nil || string(encoded) != c.expected {
+			t.Fatalf("Expected %q for %+v, got %q (%v)", c.expected, c.peers, encoded, e)
+		}
+	}
+}
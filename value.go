@@ -0,0 +1,153 @@
+package bencoding
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+)
+
+// Kind is the kind of a bencoded value.
+type Kind int
+
+const (
+	Invalid Kind = iota
+	Int
+	String
+	List
+	Dict
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Int:
+		return "integer"
+	case String:
+		return "string"
+	case List:
+		return "list"
+	case Dict:
+		return "dictionary"
+	}
+	return "invalid"
+}
+
+// Span is the byte range [Start, End) a value occupies in the input,
+// counted from the start of the input given to the decoder.
+type Span struct {
+	Start int64
+	End   int64
+}
+
+// Value holds any bencoded value. Unlike decoding into interface{},
+// decoding into a Value keeps dictionary keys in input order,
+// keeps duplicate keys and records where each value was found.
+//
+// Only the field matching Kind is set. Integers must fit in an int64.
+//
+// Value implements Marshaler: it encodes back to bencode with
+// dictionary entries in the order they are listed.
+type Value struct {
+	Kind  Kind
+	Int   int64
+	Bytes []byte
+	List  []Value
+	Dict  []DictEntry
+	Span  Span
+}
+
+// DictEntry is a single key and value of a dictionary Value.
+type DictEntry struct {
+	Key     string
+	KeySpan Span
+	Value   Value
+}
+
+var valueType = reflect.TypeOf(Value{})
+
+// Lookup returns the value of the first entry with the given key
+// in a dictionary Value, or nil if there is none.
+func (v *Value) Lookup(key string) *Value {
+	for i := range v.Dict {
+		if v.Dict[i].Key == key {
+			return &v.Dict[i].Value
+		}
+	}
+	return nil
+}
+
+func (v Value) MarshalBencode() ([]byte, error) {
+	return v.appendBencode(nil)
+}
+
+func (v *Value) appendBencode(b []byte) ([]byte, error) {
+	switch v.Kind {
+	case Int:
+		b = append(b, 'i')
+		b = strconv.AppendInt(b, v.Int, 10)
+		return append(b, 'e'), nil
+	case String:
+		return appendString(b, v.Bytes), nil
+	case List:
+		b = append(b, 'l')
+		for i := range v.List {
+			var e error
+			if b, e = v.List[i].appendBencode(b); e != nil {
+				return nil, e
+			}
+		}
+		return append(b, 'e'), nil
+	case Dict:
+		b = append(b, 'd')
+		for i := range v.Dict {
+			var e error
+			b = appendString(b, []byte(v.Dict[i].Key))
+			if b, e = v.Dict[i].Value.appendBencode(b); e != nil {
+				return nil, e
+			}
+		}
+		return append(b, 'e'), nil
+	}
+	return nil, errors.New("can't marshal value of kind '" + v.Kind.String() + "'")
+}
+
+func appendString(b []byte, s []byte) []byte {
+	b = strconv.AppendInt(b, int64(len(s)), 10)
+	b = append(b, ':')
+	return append(b, s...)
+}
+
+// unmarshalValue decodes the next value of any kind into
+// the Value pointed to by val's address.
+func (d *TorrentDecoder) unmarshalValue(val reflect.Value) error {
+	v := val.Addr().Interface().(*Value)
+	*v = Value{}
+	b, e := d.peek()
+	if e != nil {
+		return e
+	}
+	start := d.b.offset
+	switch b {
+	case 'i':
+		v.Kind = Int
+		e = d.unmarshalInt(reflect.ValueOf(&v.Int).Elem())
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		v.Kind = String
+		_, v.Bytes, e = d.readString()
+	case 'l':
+		v.Kind = List
+		e = d.unmarshalSlice(reflect.ValueOf(&v.List).Elem())
+	case 'd':
+		v.Kind = Dict
+		e = d.unmarshalDict(func(key string, keySpan Span) error {
+			v.Dict = append(v.Dict, DictEntry{Key: key, KeySpan: keySpan})
+			return d.unmarshalValue(reflect.ValueOf(&v.Dict[len(v.Dict)-1].Value).Elem())
+		})
+	default:
+		return d.syntaxError("invalid character '" + string(b) + "' looking for beginning of value")
+	}
+	if e != nil {
+		return e
+	}
+	v.Span = Span{Start: start, End: d.b.offset}
+	return nil
+}
@@ -0,0 +1,95 @@
+package bencoding
+
+import (
+	"crypto/sha1"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecodeValueKeepsOrderDuplicatesAndSpans(t *testing.T) {
+	input := "d1:bi1e1:al3:fooi-2ee1:bdee"
+	var v Value
+	if e := Unmarshal([]byte(input), &v); e != nil {
+		t.Fatal(e)
+	}
+	if v.Kind != Dict || v.Span != (Span{0, int64(len(input))}) {
+		t.Fatalf("Unexpected top level value %v %v", v.Kind, v.Span)
+	}
+	keys := []string{}
+	for _, entry := range v.Dict {
+		keys = append(keys, entry.Key)
+	}
+	if strings.Join(keys, ",") != "b,a,b" {
+		t.Fatalf("Expected keys 'b,a,b', got '%s'", strings.Join(keys, ","))
+	}
+	if v.Dict[1].KeySpan != (Span{7, 10}) {
+		t.Fatalf("Unexpected key span %v", v.Dict[1].KeySpan)
+	}
+	list := v.Dict[1].Value
+	if list.Kind != List || len(list.List) != 2 {
+		t.Fatalf("Unexpected list %+v", list)
+	}
+	if s := list.List[0]; s.Kind != String || string(s.Bytes) != "foo" || s.Span != (Span{11, 16}) {
+		t.Fatalf("Unexpected string %+v", s)
+	}
+	if i := list.List[1]; i.Kind != Int || i.Int != -2 || i.Span != (Span{16, 20}) {
+		t.Fatalf("Unexpected integer %+v", i)
+	}
+	if b := v.Lookup("b"); b == nil || b.Kind != Int || b.Int != 1 {
+		t.Fatalf("Expected first 'b' entry, got %+v", b)
+	}
+	if v.Lookup("c") != nil {
+		t.Fatalf("Expected no 'c' entry")
+	}
+}
+
+func TestMarshalValueRoundTrips(t *testing.T) {
+	input := "d1:bi1e1:al3:fooi-2ee1:bdee"
+	var v Value
+	if e := Unmarshal([]byte(input), &v); e != nil {
+		t.Fatal(e)
+	}
+	if out, e := Marshal(v); e != nil {
+		t.Fatal(e)
+	} else if string(out) != input {
+		t.Fatalf("Expected '%s', got '%s'", input, string(out))
+	}
+	if out, e := Marshal(&v); e != nil || string(out) != input {
+		t.Fatalf("Expected '%s', got '%s' (%v)", input, string(out), e)
+	}
+	if _, e := Marshal(Value{}); e == nil {
+		t.Fatalf("Expected error for invalid value")
+	}
+}
+
+func TestDecodeValueInStruct(t *testing.T) {
+	type Torrent struct {
+		Announce string `bencoding:"announce"`
+		Info     Value  `bencoding:"info"`
+	}
+	input := "d8:announce3:url4:infod4:name1:xee"
+	var torrent Torrent
+	hash, e := UnmarshalTorrent([]byte(input), &torrent)
+	if e != nil {
+		t.Fatal(e)
+	}
+	info := input[torrent.Info.Span.Start:torrent.Info.Span.End]
+	if info != "d4:name1:xe" {
+		t.Fatalf("Unexpected info span contents '%s'", info)
+	}
+	if sum := sha1.Sum([]byte(info)); hash != InfoHash(sum[:]) {
+		t.Fatalf("Expected info hash of the info span")
+	}
+}
+
+func TestDecodeValueReportsErrors(t *testing.T) {
+	var v Value
+	var se *SyntaxError
+	if e := Unmarshal([]byte("d1:ai1e1:bx"), &v); !errors.As(e, &se) || se.Field != "b" {
+		t.Fatalf("Expected SyntaxError in field 'b', got %v", e)
+	}
+	if e := Unmarshal([]byte("i99999999999999999999e"), &v); e == nil {
+		t.Fatalf("Expected error for integer overflowing int64")
+	}
+}
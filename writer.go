@@ -0,0 +1,147 @@
+package bencoding
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// writerLevel tracks a list or dictionary opened by
+// BeginList or BeginDict.
+type writerLevel struct {
+	dict    bool
+	n       int    // number of keys and values written so far
+	prevKey []byte // previous key, kept only in debug builds
+}
+
+func (l *writerLevel) wantsKey() bool {
+	return l.dict && l.n%2 == 0
+}
+
+// BeginDict writes the start of a dictionary. Its entries are
+// written as alternating keys (with WriteString or WriteBytes)
+// and values, and the dictionary is closed with End.
+// Keys must be written in sorted order; builds with the
+// bencoding_debug tag verify this.
+func (enc *Encoder) BeginDict() error {
+	return enc.begin('d')
+}
+
+// BeginList writes the start of a list, which is closed with End.
+func (enc *Encoder) BeginList() error {
+	return enc.begin('l')
+}
+
+func (enc *Encoder) begin(delim byte) error {
+	if err := enc.beginValue(); err != nil {
+		return err
+	}
+	if _, err := enc.w.Write([]byte{delim}); err != nil {
+		return err
+	}
+	enc.state = append(enc.state, writerLevel{dict: delim == 'd'})
+	return nil
+}
+
+// End closes the innermost list or dictionary.
+func (enc *Encoder) End() error {
+	if len(enc.state) == 0 {
+		return errors.New("End called without open list or dictionary")
+	}
+	if top := enc.state[len(enc.state)-1]; top.dict && !top.wantsKey() {
+		return errors.New("End called after dictionary key without value")
+	}
+	enc.state = enc.state[:len(enc.state)-1]
+	_, err := enc.w.Write([]byte{'e'})
+	return err
+}
+
+// WriteInt writes an integer.
+func (enc *Encoder) WriteInt(i int64) error {
+	if err := enc.beginValue(); err != nil {
+		return err
+	}
+	_, err := enc.w.Write(strconv.AppendInt([]byte{'i'}, i, 10))
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Write([]byte{'e'})
+	return err
+}
+
+// WriteString writes a string, which may be a dictionary key.
+func (enc *Encoder) WriteString(s string) error {
+	return enc.WriteBytes([]byte(s))
+}
+
+// WriteBytes writes b as a string, which may be a dictionary key.
+func (enc *Encoder) WriteBytes(b []byte) error {
+	if err := enc.beginKeyOrValue(b); err != nil {
+		return err
+	}
+	if _, err := enc.w.Write(strconv.AppendInt([]byte{}, int64(len(b)), 10)); err != nil {
+		return err
+	}
+	if _, err := enc.w.Write([]byte{':'}); err != nil {
+		return err
+	}
+	_, err := enc.w.Write(b)
+	return err
+}
+
+// WriteStringFrom writes a string of length n whose content is
+// copied from r, without holding it in memory. It fails if r
+// ends before n bytes were copied, in which case the output
+// is no longer valid bencode.
+func (enc *Encoder) WriteStringFrom(r io.Reader, n int64) error {
+	if n < 0 {
+		return errors.New("negative string length")
+	}
+	if err := enc.beginValue(); err != nil {
+		return err
+	}
+	if _, err := enc.w.Write(strconv.AppendInt([]byte{}, n, 10)); err != nil {
+		return err
+	}
+	if _, err := enc.w.Write([]byte{':'}); err != nil {
+		return err
+	}
+	if copied, err := io.CopyN(enc.w, r, n); err == io.EOF {
+		return errors.New("string content ended after " + strconv.FormatInt(copied, 10) + " of " + strconv.FormatInt(n, 10) + " bytes")
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// beginValue checks that a value may be written at the current
+// position and records it.
+func (enc *Encoder) beginValue() error {
+	if len(enc.state) == 0 {
+		return nil
+	}
+	top := &enc.state[len(enc.state)-1]
+	if top.wantsKey() {
+		return errors.New("dictionary key must be written with WriteString or WriteBytes")
+	}
+	top.n++
+	return nil
+}
+
+// beginKeyOrValue is beginValue for strings, which may
+// also be dictionary keys.
+func (enc *Encoder) beginKeyOrValue(b []byte) error {
+	if len(enc.state) == 0 {
+		return nil
+	}
+	top := &enc.state[len(enc.state)-1]
+	if top.wantsKey() && debug {
+		if top.n > 0 && bytes.Compare(top.prevKey, b) >= 0 {
+			return errors.New("dictionary key '" + string(b) + "' is not sorted after '" + string(top.prevKey) + "'")
+		}
+		top.prevKey = append(top.prevKey[:0], b...)
+	}
+	top.n++
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build bencoding_debug
+// +build bencoding_debug
+
+package bencoding
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncoderTokenWriterChecksKeyOrder(t *testing.T) {
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	enc.BeginDict()
+	enc.WriteString("b")
+	enc.WriteInt(1)
+	if e := enc.WriteString("a"); e == nil {
+		t.Fatalf("Expected unsorted key to fail")
+	}
+	if e := enc.WriteString("b"); e == nil {
+		t.Fatalf("Expected duplicate key to fail")
+	}
+	if e := enc.WriteString("c"); e != nil {
+		t.Fatal(e)
+	}
+}
@@ -0,0 +1,63 @@
+package bencoding
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncoderTokenWriter(t *testing.T) {
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	steps := []func() error{
+		enc.BeginDict,
+		func() error { return enc.WriteString("announce") },
+		func() error { return enc.WriteString("http://tracker") },
+		func() error { return enc.WriteString("info") },
+		enc.BeginDict,
+		func() error { return enc.WriteString("length") },
+		func() error { return enc.WriteInt(10) },
+		func() error { return enc.WriteString("name") },
+		func() error { return enc.Encode("foo") },
+		func() error { return enc.WriteString("pieces") },
+		func() error { return enc.WriteStringFrom(strings.NewReader("0123456789"), 10) },
+		enc.End,
+		func() error { return enc.WriteBytes([]byte("list")) },
+		enc.BeginList,
+		func() error { return enc.WriteInt(-1) },
+		func() error { return enc.Encode([]string{"x"}) },
+		enc.End,
+		enc.End,
+	}
+	for i, step := range steps {
+		if e := step(); e != nil {
+			t.Fatalf("Step %d failed: %v", i, e)
+		}
+	}
+	expected := "d8:announce14:http://tracker4:infod6:lengthi10e4:name3:foo6:pieces10:0123456789e4:listli-1el1:xeee"
+	if b.String() != expected {
+		t.Fatalf("Expected '%s', got '%s'", expected, b.String())
+	}
+}
+
+func TestEncoderTokenWriterErrors(t *testing.T) {
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	if e := enc.End(); e == nil {
+		t.Fatalf("Expected End without open dictionary to fail")
+	}
+	enc.BeginDict()
+	if e := enc.WriteInt(1); e == nil {
+		t.Fatalf("Expected integer dictionary key to fail")
+	}
+	if e := enc.Encode(map[string]int{}); e == nil {
+		t.Fatalf("Expected dictionary as dictionary key to fail")
+	}
+	enc.WriteString("key")
+	if e := enc.End(); e == nil {
+		t.Fatalf("Expected End after key without value to fail")
+	}
+	if e := enc.WriteStringFrom(strings.NewReader("abc"), 4); e == nil {
+		t.Fatalf("Expected short WriteStringFrom to fail")
+	}
+}